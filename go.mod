@@ -3,14 +3,13 @@ module github.com/oscarteg/toolbox
 go 1.23.3
 
 require (
+	filippo.io/age v1.2.1
+	github.com/BurntSushi/toml v1.4.0
 	github.com/adrg/frontmatter v0.2.0
+	github.com/fsnotify/fsnotify v1.9.0
 	github.com/urfave/cli/v3 v3.2.0
+	golang.org/x/sys v0.21.0
+	gopkg.in/yaml.v2 v2.4.0
 )
 
-require (
-	github.com/BurntSushi/toml v1.4.0 // indirect
-	github.com/cpuguy83/go-md2man/v2 v2.0.5 // indirect
-	github.com/russross/blackfriday/v2 v2.1.0 // indirect
-	github.com/xrash/smetrics v0.0.0-20240521201337-686a1a2994c1 // indirect
-	gopkg.in/yaml.v2 v2.4.0 // indirect
-)
+require golang.org/x/crypto v0.24.0 // indirect
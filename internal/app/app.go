@@ -5,6 +5,7 @@ import (
 
 	"github.com/oscarteg/toolbox/internal/commands/linkworktrees"
 	"github.com/oscarteg/toolbox/internal/commands/mdmeta"
+	"github.com/oscarteg/toolbox/internal/commands/worktree"
 	"github.com/urfave/cli/v3"
 )
 
@@ -19,6 +20,8 @@ EXAMPLES:
   toolbox linkworktrees -d config         # Link files from ./config directory
   toolbox mdmeta update                   # Update markdown metadata from frontmatter
   toolbox mdmeta update -d ./posts -r     # Process ./posts recursively
+  toolbox worktree new feature-x          # Create a linked worktree in ../feature-x
+  toolbox link --to ~                     # Link files from ./links into your home directory
 
 Run 'toolbox <command> --help' for more information on a specific command.`,
 		Version: "0.1.0",
@@ -34,7 +37,9 @@ Run 'toolbox <command> --help' for more information on a specific command.`,
 		},
 		Commands: []*cli.Command{
 			linkworktrees.NewCommand(),
+			linkworktrees.NewLinkCommand(),
 			mdmeta.NewCommand(),
+			worktree.NewCommand(),
 		},
 	}
 }
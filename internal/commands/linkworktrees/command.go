@@ -1,9 +1,104 @@
 package linkworktrees
 
 import (
+	"runtime"
+	"time"
+
 	"github.com/urfave/cli/v3"
 )
 
+// linksDirFlag returns the flag selecting the directories containing the files to link.
+// Each command gets its own flag instances since flags keep their parsed state.
+func linksDirFlag() cli.Flag {
+	return &cli.StringSliceFlag{
+		Name:    "links-dir",
+		Aliases: []string{"d"},
+		Usage:   "Directory containing files to link, repeat to overlay layers with later ones taking precedence (default: the manifest layers or links)",
+	}
+}
+
+// repoFlag returns the flag pointing at the repository to operate on
+func repoFlag() cli.Flag {
+	return &cli.StringFlag{
+		Name:  "repo",
+		Usage: "Operate on the repository containing this directory (default: the current directory)",
+	}
+}
+
+// sourceFlags returns the flags that decide what gets linked
+func sourceFlags() []cli.Flag {
+	return []cli.Flag{
+		linksDirFlag(),
+		&cli.StringFlag{
+			Name:    "manifest",
+			Aliases: []string{"m"},
+			Usage:   "Manifest listing the entries to link (default: toolbox.links.toml or toolbox.links.yaml if present)",
+		},
+		&cli.StringSliceFlag{
+			Name:  "dir",
+			Usage: "Directory glob pattern, relative to the links directory, to link as a whole (repeatable)",
+			Value: defaultDirs,
+		},
+		&cli.StringFlag{
+			Name:  "mode",
+			Usage: "How entries are materialized: symlink, relative-symlink, hardlink or copy",
+			Value: string(modeSymlink),
+		},
+		&cli.StringSliceFlag{
+			Name:    "identity",
+			Aliases: []string{"i"},
+			Usage:   "age identity file to decrypt .age sources with (repeatable, default: identities in the manifest)",
+		},
+		&cli.BoolFlag{
+			Name:  "relative",
+			Usage: "Create symlinks relative to the target directory, so the repository can be moved or bind-mounted (same as --mode relative-symlink)",
+		},
+	}
+}
+
+// worktreeFlags returns the flags that select the repository and the worktrees to operate on
+func worktreeFlags() []cli.Flag {
+	return []cli.Flag{
+		repoFlag(),
+		&cli.BoolFlag{
+			Name:  "include-main",
+			Usage: "Also operate on the main worktree",
+		},
+		&cli.StringSliceFlag{
+			Name:    "worktree",
+			Aliases: []string{"w"},
+			Usage:   "Only operate on the worktree at this path (repeatable)",
+		},
+		&cli.StringSliceFlag{
+			Name:    "branch",
+			Aliases: []string{"b"},
+			Usage:   "Only operate on worktrees with a branch matching this glob pattern (repeatable)",
+		},
+		&cli.StringSliceFlag{
+			Name:  "exclude",
+			Usage: "Skip worktrees whose path or directory name matches this glob pattern (repeatable)",
+		},
+		&cli.BoolFlag{
+			Name:  "include-locked",
+			Usage: "Also operate on locked worktrees",
+		},
+		&cli.BoolFlag{
+			Name:  "recurse-submodules",
+			Usage: "Link the links/<submodule path> files into the checked out submodules of each worktree",
+		},
+	}
+}
+
+// dryRunFlag returns the flag previewing changes without applying them
+func dryRunFlag() cli.Flag {
+	return &cli.BoolFlag{
+		Name:    "dry-run",
+		Aliases: []string{"n"},
+		Usage:   "Show what would be done without making changes",
+		Value:   false,
+	}
+}
+
 // NewCommand creates a new linkworktrees command
 func NewCommand() *cli.Command {
 	return &cli.Command{
@@ -11,33 +106,347 @@ func NewCommand() *cli.Command {
 		Aliases: []string{"lw"},
 		Usage:   "Symlink files from links folder to all git worktrees",
 		Description: `This command symlinks files from a 'links' directory to all git worktrees.
-Special directories like .claude are linked as entire directories, while other files
-are linked individually with their directory structure preserved.
+Directories matching --dir (default: .claude) are linked as entire directories, while
+other files are linked individually with their directory structure preserved. --dir
+takes glob patterns relative to the links directory and can be repeated.
+
+When a toolbox.links.toml (or toolbox.links.yaml) manifest exists in the main
+worktree, it can configure the whole-directory set, or list exactly which entries
+are linked instead of walking the links directory:
+
+  dirs = [".claude", ".vscode", ".idea", ".direnv", "node_modules/.cache"]
+
+  [[links]]
+  source = ".claude"        # path inside the links directory
+  dir = true                # link the directory as a whole
+
+  [[links]]
+  source = "env/local.env"
+  target = ".env"           # path inside the worktree, defaults to source
+  optional = true           # skip when the source does not exist
+
+The manifest is validated before any worktree is changed.
+
+Relative links directories and the manifest are looked up in the main worktree, so the
+command works the same from any worktree or subdirectory of the repository. --repo
+operates on the repository containing another directory instead.
+
+--links-dir can be repeated to overlay links directories, e.g. a team-wide one and a
+personal one. Later directories override earlier ones per path, and directories that
+do not exist are skipped. Without --links-dir, the manifest can list them:
+
+  layers = ["links", "~/.config/toolbox/links/{{.Repo}}"]
+
+~, environment variables and {{.Repo}} (the directory name of the main worktree) are
+expanded. status shows the layer each link comes from.
+
+Hidden files are not linked by default, except templates and encrypted files like
+.env.tmpl and .env.age. A .linkignore file, in the links directory or
+any directory below it, lists paths to skip with gitignore syntax, including negation:
+
+  README.md
+  *.swp
+  !.envrc
+
+The manifest can replace the default with ignore = [...], e.g. ignore = [] to link
+hidden files as well.
+
+--mode decides how entries are materialized. symlink (the default) creates absolute
+symlinks, relative-symlink creates symlinks relative to the target directory, and
+hardlink and copy create real files for tools that do not cope with symlinks. Copies
+are tracked by content hash, so re-running only rewrites files whose source changed.
+Directories are linked file by file in hardlink and copy mode. The manifest can set
+the default with a top-level mode = "relative-symlink", and entries can override it.
+Entries set the permissions of copies, rendered templates and decrypted files with
+perm = "0600", and those of the parent directories created for them with
+dir_perm = "0700" (default 0755). status reports files with other permissions as stale.
+
+Use --relative (or mode = "relative-symlink") when the repository and its worktrees
+get moved or bind-mounted into containers at a different path. Re-running converts
+existing links to the requested form, and status accepts both forms as linked.
+
+Files ending in .tmpl are rendered with Go text/template for each worktree and written
+as real files without the extension, e.g. links/.env.tmpl becomes .env. Templates can use
+.Path, .Name, .Branch, .Head, .Index (position in 'git worktree list', main is 0) and
+.Main, plus the add and replace functions: PORT={{add 3000 .Index}}. Re-running renders
+them again when the template or the checked out branch changed.
+
+Files ending in .age are decrypted with age for each worktree and written as real files
+without the extension, readable by their owner only (0600), e.g. links/.env.age becomes
+.env. --identity (or identities = [...] in the manifest) names the age identity files to
+decrypt with. status reports them as stale when the source changed or their permissions
+were widened.
+
+Every linked worktree is used except the main one. --include-main adds the main
+worktree, --worktree and --branch select worktrees by path or branch glob, and
+--exclude skips worktrees by path or directory name glob. Bare and prunable worktrees
+are always skipped, and locked ones unless --include-locked is given.
+
+Existing files and directories that are not links created by this command are left
+alone by default. Use --on-conflict to change this: backup moves them to a timestamped
+<name>.<YYYYMMDD-HHMMSS>.bak path (undo with 'toolbox lw restore'), overwrite deletes
+them and fail aborts before changing anything.
+
+--recurse-submodules links the part of the links directory at a submodule path, e.g.
+links/libs/core/..., into that submodule in every worktree. Submodules that are not
+checked out are skipped, and linked paths are excluded in the submodule's own
+info/exclude. Templates in a submodule render with its .Path, .Name, .Branch and .Head.
+
+--dry-run --format json (or ndjson, one op per line) prints the plan instead: the action
+for every target (keep, skip, create, update, backup or overwrite) with its source,
+worktree, reason and whether a conflict is in the way. 'toolbox lw apply --plan' runs such
+a plan exactly, and refuses when a source or target changed since it was made.
+
+Worktrees are linked concurrently, --jobs at a time (default: the number of CPUs).
+The output stays in the same order as a sequential run.
 
 EXAMPLES:
   toolbox linkworktrees                   # Link files from ./links to all worktrees
   toolbox lw -d config                    # Link files from ./config directory
   toolbox linkworktrees --links-dir=dots  # Link files from ./dots directory
   toolbox lw --dry-run                    # Preview what would be linked
+  toolbox lw -n -f json > plan.json       # Write the plan for review, then run it
+  toolbox lw apply --plan plan.json       #   with apply, unless anything changed
+  toolbox lw --manifest team.links.toml   # Link only the entries listed in a manifest
+  toolbox lw -d links -d ~/my-links       # Overlay personal files on the team links
+  toolbox lw --dir .vscode --dir '.idea*' # Link .vscode and .idea* directories as a whole
+  toolbox lw unlink                       # Remove all links pointing into ./links
+  toolbox lw status --format json         # Report missing or drifted links
+  toolbox lw --on-conflict=backup         # Move existing files aside before linking
+  toolbox lw restore                      # Put backed up files back
+  toolbox lw --mode copy                  # Copy files instead of symlinking them
+  toolbox lw --relative                   # Create relocatable relative symlinks
+  toolbox lw --include-main               # Link into the main worktree as well
+  toolbox lw --branch 'feature/*'         # Only link into feature branch worktrees
+  toolbox lw -w ../repo-hotfix            # Only link into one worktree
+  toolbox lw --repo ~/src/api             # Link the worktrees of another repository
+  toolbox lw --recurse-submodules         # Also link links/<submodule> into submodules
+  toolbox lw install-hook                 # Link new worktrees automatically
+  toolbox lw watch                        # Keep worktrees in sync while files change
 
 The command will:
 1. Find all git worktrees in the current repository
 2. Create symbolic links from the source directory to each worktree
 3. Preserve directory structure for individual files
-4. Link special directories (like .claude) as complete directories`,
+4. Link whole directories (like .claude) as complete directories`,
 		Action: handleLinkWorktrees,
-		Flags: []cli.Flag{
+		Flags: append(append(sourceFlags(), worktreeFlags()...), dryRunFlag(),
 			&cli.StringFlag{
-				Name:    "links-dir",
-				Aliases: []string{"d"},
-				Usage:   "Directory containing files to link",
-				Value:   "links",
-			},
-			&cli.BoolFlag{
-				Name:    "dry-run",
-				Aliases: []string{"n"},
-				Usage:   "Show what would be done without making changes",
-				Value:   false,
+				Name:  "on-conflict",
+				Usage: "What to do with existing files that are not links: skip, backup, overwrite or fail",
+				Value: string(policySkip),
+			},
+			&cli.IntFlag{
+				Name:    "jobs",
+				Aliases: []string{"j"},
+				Usage:   "Number of worktrees to link concurrently",
+				Value:   runtime.NumCPU(),
+			},
+			&cli.StringFlag{
+				Name:    "format",
+				Aliases: []string{"f"},
+				Usage:   "Dry run output format: text, or json and ndjson for a plan to run with apply --plan",
+				Value:   "text",
+			},
+		),
+		Commands: []*cli.Command{
+			{
+				Name:  "apply",
+				Usage: "Execute a plan written by a dry run with --format json or ndjson",
+				Description: `Carries out every action of a reviewed plan exactly as listed, without deciding anything anew:
+
+  toolbox lw --dry-run --format json > plan.json
+  toolbox lw apply --plan plan.json
+
+Each planned op records the state of its source and target. When any of them changed
+since the dry run, apply refuses to change anything, so make a new plan. Like linking
+itself, the plan runs as a transaction that is rolled back when it fails.`,
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:     "plan",
+						Usage:    "Plan file written by --dry-run --format json or ndjson",
+						Required: true,
+					},
+					&cli.IntFlag{
+						Name:    "jobs",
+						Aliases: []string{"j"},
+						Usage:   "Number of worktrees to link concurrently",
+						Value:   runtime.NumCPU(),
+					},
+					repoFlag(),
+				},
+				Action: handleApply,
+			},
+			{
+				Name:    "unlink",
+				Aliases: []string{"clean"},
+				Usage:   "Remove the links and copies made by linkworktrees from all git worktrees",
+				Description: `Walks every worktree and removes the symlinks that point into the links directory,
+and the hardlinks, copies, rendered templates and decrypted files that linkworktrees
+recorded writing as long as they still hold what it wrote. Then prunes directories
+that were created for them and are now empty. Symlinks pointing elsewhere, files
+edited since they were written and any other files are never touched.`,
+				Flags:  append([]cli.Flag{linksDirFlag(), dryRunFlag()}, worktreeFlags()...),
+				Action: handleUnlink,
+			},
+			{
+				Name:    "status",
+				Aliases: []string{"st"},
+				Usage:   "Report links that are missing or drifted in any git worktree",
+				Description: `Checks every file that would be linked against every worktree and reports its state:
+
+  linked     the target is a symlink to the expected source, or a hardlink, copy, rendered
+             template or decrypted file with the expected content and permissions
+  missing    nothing exists at the target
+  stale      the target is a symlink to a different source, or a file linkworktrees wrote
+             whose source changed since or whose permissions differ from the configured ones
+  dangling   the target is a symlink to a path that does not exist
+  shadowed   a real file or directory linkworktrees did not write, or a file it wrote
+             that was edited since, exists at the target
+
+Each link also shows the links directory (layer) its source comes from.
+
+Exits with a non-zero status when anything is not linked, so it can be used
+in pre-commit hooks and CI.`,
+				Flags: append(append(sourceFlags(), worktreeFlags()...),
+					&cli.StringFlag{
+						Name:    "format",
+						Aliases: []string{"f"},
+						Usage:   "Output format: table or json",
+						Value:   "table",
+					},
+				),
+				Action: handleStatus,
+			},
+			{
+				Name:  "restore",
+				Usage: "Put entries backed up by --on-conflict=backup back in place of their links",
+				Description: `Finds the timestamped .bak entries created by --on-conflict=backup in every worktree
+and moves the most recent backup of each path back, replacing the link that took its
+place. Paths that hold anything other than a link into the links directory are left alone.`,
+				Flags:  append([]cli.Flag{linksDirFlag(), dryRunFlag()}, worktreeFlags()...),
+				Action: handleRestore,
+			},
+			{
+				Name:  "watch",
+				Usage: "Keep worktrees linked while files and worktrees come and go",
+				Description: `Links everything once, then watches the links directory and the git worktrees
+directory. New or changed sources are linked into every worktree, links whose source
+was removed are deleted, and new worktrees get linked as soon as they are added.
+Bursts of changes are handled together once nothing changed for --debounce.
+Conflicts are reported once and skipped unless --on-conflict is backup or overwrite.
+Stops on Ctrl+C.`,
+				Flags: append(append(sourceFlags(), worktreeFlags()...),
+					&cli.StringFlag{
+						Name:  "on-conflict",
+						Usage: "What to do with existing files that are not links: skip, backup or overwrite",
+						Value: string(policySkip),
+					},
+					&cli.DurationFlag{
+						Name:  "debounce",
+						Usage: "How long changes must settle before syncing",
+						Value: 300 * time.Millisecond,
+					},
+				),
+				Action: handleWatch,
+			},
+			{
+				Name:  "install-hook",
+				Usage: "Install a post-checkout hook that links new worktrees when they are created",
+				Description: `Adds a block to the post-checkout hook of the repository, in core.hooksPath when it
+is set. When 'git worktree add' checks out a new worktree, the hook runs
+'toolbox linkworktrees --worktree <new worktree>' from the main worktree, so only
+the new worktree gets linked. An existing hook is kept and the block is added right after
+its shebang, so it also runs when the hook ends with exit or exec. Running
+install-hook again replaces the block.`,
+				Flags:  []cli.Flag{linksDirFlag(), repoFlag()},
+				Action: handleInstallHook,
+			},
+			{
+				Name:        "uninstall-hook",
+				Usage:       "Remove the block added by install-hook from the post-checkout hook",
+				Description: "Removes the linkworktrees block from the post-checkout hook, and the hook itself when nothing else is left in it.",
+				Flags:       []cli.Flag{repoFlag()},
+				Action:      handleUninstallHook,
+			},
+		},
+	}
+}
+
+// NewLinkCommand creates the link command, which links into arbitrary directories instead of git worktrees
+func NewLinkCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "link",
+		Usage:     "Symlink files from links folder into any directories",
+		ArgsUsage: "--to <dir>...",
+		Description: `Links the links directory into the directories given with --to, e.g. sibling
+repositories or your home directory, like linkworktrees does for git worktrees. Without
+--to, the directories come from targets in the manifest:
+
+  targets = ["~", "../api", "../web"]
+
+~, environment variables and {{.Repo}} are expanded. Everything else works as in
+linkworktrees: layers, the manifest, link modes, templates, --dry-run, --on-conflict
+and --jobs, and the status, unlink and restore subcommands.
+
+Templates get .Path, .Name and .Index (position in the list of targets), and .Branch,
+.Head and .Main when the target is a git worktree.
+
+Hidden files are linked, as dotfiles usually are. Only a .git in the links directory is
+skipped. unlink does not walk the target directories: it looks for links only in the
+directories leading to what would be linked, and for the recorded copies.
+
+Link records and the journal are kept in the git common dir when run inside a
+repository, and in $XDG_STATE_HOME/toolbox (default: ~/.local/state/toolbox) otherwise.
+info/exclude is not updated.
+
+EXAMPLES:
+  toolbox link --to ~                     # Link ./links into your home directory
+  toolbox link -d dotfiles -t ~ --dry-run # Preview linking ./dotfiles into ~
+  toolbox link -t ../api -t ../web        # Share files with sibling repositories
+  toolbox link status                     # Report drift in the manifest targets
+  toolbox link unlink -t ~                # Remove the links again`,
+		Action: handleLinkWorktrees,
+		Flags: append(sourceFlags(), toFlag(), dryRunFlag(),
+			&cli.StringFlag{
+				Name:  "on-conflict",
+				Usage: "What to do with existing files that are not links: skip, backup, overwrite or fail",
+				Value: string(policySkip),
+			},
+			&cli.IntFlag{
+				Name:    "jobs",
+				Aliases: []string{"j"},
+				Usage:   "Number of directories to link concurrently",
+				Value:   runtime.NumCPU(),
+			},
+		),
+		Commands: []*cli.Command{
+			{
+				Name:    "unlink",
+				Aliases: []string{"clean"},
+				Usage:   "Remove links pointing into the links folder from the target directories",
+				Flags:   []cli.Flag{linksDirFlag(), toFlag(), dryRunFlag()},
+				Action:  handleUnlink,
+			},
+			{
+				Name:    "status",
+				Aliases: []string{"st"},
+				Usage:   "Report links that are missing or drifted in any target directory",
+				Flags: append(sourceFlags(), toFlag(),
+					&cli.StringFlag{
+						Name:    "format",
+						Aliases: []string{"f"},
+						Usage:   "Output format: table or json",
+						Value:   "table",
+					},
+				),
+				Action: handleStatus,
+			},
+			{
+				Name:   "restore",
+				Usage:  "Put entries backed up by --on-conflict=backup back in place of their links",
+				Flags:  []cli.Flag{linksDirFlag(), toFlag(), dryRunFlag()},
+				Action: handleRestore,
 			},
 		},
 	}
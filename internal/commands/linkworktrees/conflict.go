@@ -0,0 +1,268 @@
+package linkworktrees
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/urfave/cli/v3"
+)
+
+// conflictPolicy decides what happens to an existing entry that is in the way of a link
+type conflictPolicy string
+
+const (
+	policySkip      conflictPolicy = "skip"
+	policyBackup    conflictPolicy = "backup"
+	policyOverwrite conflictPolicy = "overwrite"
+	policyFail      conflictPolicy = "fail"
+)
+
+// backupTimeFormat is the timestamp layout used in backup names
+const backupTimeFormat = "20060102-150405"
+
+// backupPattern matches backup names and captures the original name
+var backupPattern = regexp.MustCompile(`^(.+)\.(\d{8}-\d{6})\.bak$`)
+
+// parseConflictPolicy validates a --on-conflict value
+func parseConflictPolicy(s string) (conflictPolicy, error) {
+	switch p := conflictPolicy(s); p {
+	case policySkip, policyBackup, policyOverwrite, policyFail:
+		return p, nil
+	default:
+		return "", fmt.Errorf("unsupported conflict policy %q, expected skip, backup, overwrite or fail", s)
+	}
+}
+
+// isConflict reports whether the target holds something the toolbox did not create:
+// a real file or directory, or a symlink pointing outside the links directories
+func isConflict(status linkStatus, linksDirs []string) bool {
+	switch status.State {
+	case stateShadowed:
+		return true
+	case stateStale, stateDangling:
+		return !isWithinAny(linksDirs, status.Actual)
+	default:
+		return false
+	}
+}
+
+// backupPath returns the timestamped path an existing entry is moved to
+func backupPath(target string, now time.Time) string {
+	return fmt.Sprintf("%s.%s.bak", target, now.Format(backupTimeFormat))
+}
+
+// clearTarget makes room for a link at the op target, applying the conflict policy to entries the toolbox did not create.
+// When the target is reached through a symlinked parent directory, that symlink is what gets cleared.
+// Removals and backups go through tx, so they can be rolled back, and backups are reported to out.
+func clearTarget(tx *transaction, out io.Writer, op linkOp, status linkStatus, linksDirs []string, policy conflictPolicy, now time.Time) error {
+	path := op.target
+	if status.parent != "" {
+		path = status.parent
+	}
+
+	// Another op may already have cleared a shared parent, or replaced it with a directory for its own link
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) || (err == nil && status.parent != "" && info.Mode()&os.ModeSymlink == 0) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case status.State == stateMissing:
+		return nil
+	case !isConflict(status, linksDirs):
+		// One of our own links or copies holding an outdated source. A real directory only
+		// gets here when every file inside it was verified to be one of our own copies.
+		if err := tx.remove(path, info.IsDir()); err != nil {
+			return fmt.Errorf("failed to remove existing %s: %w", path, err)
+		}
+	case policy == policyBackup:
+		backup := backupPath(path, now)
+		if err := tx.rename(path, backup); err != nil {
+			return fmt.Errorf("failed to back up %s: %w", path, err)
+		}
+		fmt.Fprintf(out, "  Backed up %s -> %s\n", path, backup)
+	case policy == policyOverwrite:
+		if status.parent != "" {
+			// Only ever remove the symlink itself, never what it points to
+			if err := tx.remove(path, false); err != nil {
+				return fmt.Errorf("failed to remove existing %s: %w", path, err)
+			}
+			return nil
+		}
+		if err := tx.remove(path, true); err != nil {
+			return fmt.Errorf("failed to remove existing %s: %w", path, err)
+		}
+	default:
+		return fmt.Errorf("refusing to replace %s with conflict policy %q", path, policy)
+	}
+	return nil
+}
+
+// handleRestore moves the most recent backup of every path back in place of its link
+func handleRestore(ctx context.Context, cmd *cli.Command) error {
+	opts, err := newLinkOptions(ctx, cmd)
+	if err != nil {
+		return err
+	}
+
+	if opts.dryRun {
+		fmt.Println("DRY RUN: No changes will be made")
+		fmt.Println()
+	}
+
+	tx, err := lockRun(opts)
+	if err != nil {
+		return err
+	}
+	defer tx.close()
+
+	l, err := newLinker(opts)
+	if err != nil {
+		return err
+	}
+
+	worktrees, err := opts.selectTargets(ctx)
+	if err != nil {
+		return err
+	}
+
+	restored := 0
+	for _, worktree := range worktrees {
+		backups, err := findBackups(ctx, worktree)
+		if err != nil {
+			return fmt.Errorf("failed to scan %s: %w", worktree, err)
+		}
+		if len(backups) == 0 {
+			continue
+		}
+
+		fmt.Printf("Restoring backups in %s...\n", worktree)
+
+		originals := make([]string, 0, len(backups))
+		for original := range backups {
+			originals = append(originals, original)
+		}
+		sort.Strings(originals)
+
+		var targets []string
+		for _, original := range originals {
+			backup := backups[original]
+
+			ok, err := canRestore(original, l.linksDirs, l.records)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				fmt.Printf("  ! Skipped %s (not a link created by linkworktrees)\n", original)
+				continue
+			}
+
+			if opts.dryRun {
+				fmt.Printf("  Would restore: %s -> %s\n", backup, original)
+				continue
+			}
+
+			if err := os.Remove(original); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("failed to remove link %s: %w", original, err)
+			}
+			if err := os.Rename(backup, original); err != nil {
+				return fmt.Errorf("failed to restore %s: %w", backup, err)
+			}
+			delete(l.records.Entries, original)
+			fmt.Printf("  <- %s\n", original)
+			restored++
+			if rel, err := filepath.Rel(worktree, original); err == nil {
+				targets = append(targets, rel)
+			}
+		}
+		fmt.Println()
+
+		if opts.recurseSubmodules && !opts.dryRun {
+			if err := pruneSubmoduleExclude(ctx, worktree, targets); err != nil {
+				return err
+			}
+		}
+	}
+
+	if !opts.dryRun {
+		if err := l.records.save(); err != nil {
+			return fmt.Errorf("failed to save link records: %w", err)
+		}
+		// The restored files are real files again, which git has to see
+		if err := pruneExclude(ctx, opts, l); err != nil {
+			return err
+		}
+		fmt.Printf("Restored %d backups\n", restored)
+	}
+	return nil
+}
+
+// findBackups maps every backed up path inside worktree to its most recent backup
+func findBackups(ctx context.Context, worktree string) (map[string]string, error) {
+	backups := make(map[string]string)
+
+	err := filepath.WalkDir(worktree, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		// Check for context cancellation
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+
+		if d.IsDir() && d.Name() == ".git" {
+			return filepath.SkipDir
+		}
+
+		match := backupPattern.FindStringSubmatch(d.Name())
+		if match == nil {
+			return nil
+		}
+
+		// Timestamps sort lexically, so the greatest name is the most recent backup
+		original := filepath.Join(filepath.Dir(path), match[1])
+		if current, ok := backups[original]; !ok || path > current {
+			backups[original] = path
+		}
+
+		if d.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+
+	return backups, err
+}
+
+// canRestore reports whether original is free or only holds a link into one of linksDirs or an unmodified recorded copy
+func canRestore(original string, linksDirs []string, records *linkRecords) (bool, error) {
+	info, err := os.Lstat(original)
+	if os.IsNotExist(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if info.Mode()&os.ModeSymlink == 0 {
+		return isRecorded(original, records)
+	}
+
+	dest, err := readLinkDest(original)
+	if err != nil {
+		return false, err
+	}
+	return isWithinAny(linksDirs, dest), nil
+}
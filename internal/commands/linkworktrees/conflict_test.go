@@ -0,0 +1,122 @@
+package linkworktrees
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseConflictPolicy(t *testing.T) {
+	for _, valid := range []string{"skip", "backup", "overwrite", "fail"} {
+		if _, err := parseConflictPolicy(valid); err != nil {
+			t.Errorf("parseConflictPolicy(%q) unexpected error = %v", valid, err)
+		}
+	}
+	if _, err := parseConflictPolicy("merge"); err == nil {
+		t.Error("parseConflictPolicy() expected error for unknown policy")
+	}
+}
+
+func TestIsConflict(t *testing.T) {
+	linksDir := "/repo/links"
+
+	tests := []struct {
+		name   string
+		status linkStatus
+		want   bool
+	}{
+		{"missing", linkStatus{State: stateMissing}, false},
+		{"linked", linkStatus{State: stateLinked}, false},
+		{"shadowed", linkStatus{State: stateShadowed}, true},
+		{"stale link into links dir", linkStatus{State: stateStale, Actual: "/repo/links/old.txt"}, false},
+		{"stale link elsewhere", linkStatus{State: stateStale, Actual: "/home/user/file.txt"}, true},
+		{"dangling link into links dir", linkStatus{State: stateDangling, Actual: "/repo/links/gone.txt"}, false},
+		{"dangling link elsewhere", linkStatus{State: stateDangling, Actual: "/gone.txt"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isConflict(tt.status, []string{linksDir}); got != tt.want {
+				t.Errorf("isConflict() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBackupAndFindBackups(t *testing.T) {
+	worktree := t.TempDir()
+	target := filepath.Join(worktree, "sub", "file.txt")
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		t.Fatalf("failed to create dir: %v", err)
+	}
+
+	older := time.Date(2024, 1, 15, 10, 30, 0, 0, time.UTC)
+	newer := older.Add(time.Hour)
+
+	for _, now := range []time.Time{older, newer} {
+		if err := os.WriteFile(target, []byte(now.String()), 0644); err != nil {
+			t.Fatalf("failed to create file: %v", err)
+		}
+		op := linkOp{entry: linkEntry{source: "/links/file.txt", target: "sub/file.txt"}, worktree: worktree, target: target}
+		if err := clearTarget(nil, io.Discard, op, linkStatus{State: stateShadowed}, []string{"/links"}, policyBackup, now); err != nil {
+			t.Fatalf("clearTarget() error = %v", err)
+		}
+		if _, err := os.Lstat(target); !os.IsNotExist(err) {
+			t.Fatalf("clearTarget() left target in place")
+		}
+	}
+
+	backups, err := findBackups(context.Background(), worktree)
+	if err != nil {
+		t.Fatalf("findBackups() error = %v", err)
+	}
+
+	want := filepath.Join(worktree, "sub", "file.txt.20240115-113000.bak")
+	if got := backups[target]; got != want {
+		t.Errorf("findBackups() latest backup = %q, want %q", got, want)
+	}
+	if len(backups) != 1 {
+		t.Errorf("findBackups() returned %d originals, want 1", len(backups))
+	}
+}
+
+func TestClearTargetSkipRefuses(t *testing.T) {
+	worktree := t.TempDir()
+	target := filepath.Join(worktree, "file.txt")
+	if err := os.WriteFile(target, []byte("mine"), 0644); err != nil {
+		t.Fatalf("failed to create file: %v", err)
+	}
+
+	op := linkOp{entry: linkEntry{source: "/links/file.txt", target: "file.txt"}, worktree: worktree, target: target}
+	if err := clearTarget(nil, io.Discard, op, linkStatus{State: stateShadowed}, []string{"/links"}, policySkip, time.Now()); err == nil {
+		t.Error("clearTarget() expected error when the policy does not allow replacing")
+	}
+	if _, err := os.Stat(target); err != nil {
+		t.Errorf("clearTarget() removed a conflicting file: %v", err)
+	}
+}
+
+func TestClearTargetKeepsReplacedParent(t *testing.T) {
+	worktree := t.TempDir()
+	parent := filepath.Join(worktree, ".claude")
+	linked := filepath.Join(parent, "a.json")
+	if err := os.MkdirAll(parent, 0755); err != nil {
+		t.Fatalf("failed to create dir: %v", err)
+	}
+	if err := os.Symlink("/links/.claude/a.json", linked); err != nil {
+		t.Fatalf("failed to create symlink: %v", err)
+	}
+
+	// Checked while .claude was still a symlink into the links directory, which an earlier op replaced since
+	op := linkOp{entry: linkEntry{source: "/links/.claude/b.json", target: ".claude/b.json"}, worktree: worktree, target: filepath.Join(parent, "b.json")}
+	status := linkStatus{State: stateStale, Actual: "/links/.claude", parent: parent}
+	if err := clearTarget(nil, io.Discard, op, status, []string{"/links"}, policySkip, time.Now()); err != nil {
+		t.Fatalf("clearTarget() error = %v", err)
+	}
+	if _, err := os.Lstat(linked); err != nil {
+		t.Errorf("clearTarget() removed the directory another op created: %v", err)
+	}
+}
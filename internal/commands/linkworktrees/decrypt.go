@@ -0,0 +1,152 @@
+package linkworktrees
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+)
+
+// encryptedExt marks age encrypted files in the links directory, which are decrypted per worktree instead of linked
+const encryptedExt = ".age"
+
+// decryptedPerm is the permission of decrypted files, readable by their owner only
+const decryptedPerm os.FileMode = 0600
+
+// decryptor turns an encrypted source into its plaintext
+type decryptor interface {
+	decrypt(source string) ([]byte, error)
+}
+
+// ageDecryptor decrypts age files with the identities in identityFiles, which are read on first use
+// so runs without encrypted sources never need them
+type ageDecryptor struct {
+	identityFiles []string
+
+	once       sync.Once
+	identities []age.Identity
+	err        error
+}
+
+// decrypt returns the plaintext of the age file at source, which may be ASCII armored
+func (d *ageDecryptor) decrypt(source string) ([]byte, error) {
+	d.once.Do(func() { d.identities, d.err = loadIdentities(d.identityFiles) })
+	if d.err != nil {
+		return nil, d.err
+	}
+
+	f, err := os.Open(source)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	in := bufio.NewReader(f)
+	var src io.Reader = in
+	if start, _ := in.Peek(len(armor.Header)); string(start) == armor.Header {
+		src = armor.NewReader(in)
+	}
+
+	r, err := age.Decrypt(src, d.identities...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt %s: %w", source, err)
+	}
+	plaintext, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt %s: %w", source, err)
+	}
+	return plaintext, nil
+}
+
+// loadIdentities reads the age identities in every file of paths
+func loadIdentities(paths []string) ([]age.Identity, error) {
+	if len(paths) == 0 {
+		return nil, errors.New("no age identity to decrypt with, pass --identity or list identities in the manifest")
+	}
+
+	var identities []age.Identity
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read identity file: %w", err)
+		}
+		ids, err := age.ParseIdentities(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse identity file %s: %w", path, err)
+		}
+		identities = append(identities, ids...)
+	}
+	return identities, nil
+}
+
+// plaintext returns the decrypted source of op, decrypting every source once per run
+func (l *linker) plaintext(op linkOp) ([]byte, error) {
+	l.mu.Lock()
+	content, ok := l.decrypted[op.entry.source]
+	l.mu.Unlock()
+	if ok {
+		return content, nil
+	}
+
+	if l.decryptor == nil {
+		return nil, fmt.Errorf("no decryptor for %s", op.entry.source)
+	}
+	content, err := l.decryptor.decrypt(op.entry.source)
+	if err != nil {
+		return nil, err
+	}
+
+	l.mu.Lock()
+	if l.decrypted == nil {
+		l.decrypted = make(map[string][]byte)
+	}
+	l.decrypted[op.entry.source] = content
+	l.mu.Unlock()
+	return content, nil
+}
+
+// decryptFile writes the decrypted source of op, readable by its owner only unless configured otherwise
+func (l *linker) decryptFile(op linkOp) error {
+	content, err := l.plaintext(op)
+	if err != nil {
+		return err
+	}
+	perm, _ := op.entry.wantPerm()
+	return writeFile(op.target, bytes.NewReader(content), perm)
+}
+
+// resolveIdentities returns the identity files to decrypt with: identities when given, otherwise the ones
+// listed in the manifest. Like links directories, each is expanded with expandPath and relative ones are
+// taken relative to o.root.
+func (o linkOptions) resolveIdentities(ctx context.Context, identities []string) ([]string, error) {
+	if len(identities) == 0 {
+		m, err := loadManifestIfAny(o.manifest, o.root)
+		if err != nil {
+			return nil, err
+		}
+		if m != nil {
+			identities = m.Identities
+		}
+	}
+
+	resolved := make([]string, 0, len(identities))
+	for _, id := range identities {
+		expanded, err := expandPath(id, mainRepoName(ctx, o.repo))
+		if err != nil {
+			return nil, err
+		}
+		if o.root != "" && !filepath.IsAbs(expanded) {
+			expanded = filepath.Join(o.root, expanded)
+		}
+		resolved = append(resolved, expanded)
+	}
+	return resolved, nil
+}
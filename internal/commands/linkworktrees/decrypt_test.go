@@ -0,0 +1,242 @@
+package linkworktrees
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+)
+
+// writeEncrypted encrypts plaintext to recipient at path, ASCII armored when armored is set
+func writeEncrypted(t *testing.T, path string, recipient age.Recipient, plaintext string, armored bool) {
+	t.Helper()
+	var buf bytes.Buffer
+	var out io.Writer = &buf
+	var armorWriter io.WriteCloser
+	if armored {
+		armorWriter = armor.NewWriter(&buf)
+		out = armorWriter
+	}
+
+	w, err := age.Encrypt(out, recipient)
+	if err != nil {
+		t.Fatalf("age.Encrypt() error = %v", err)
+	}
+	if _, err := io.WriteString(w, plaintext); err != nil {
+		t.Fatalf("failed to encrypt: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("failed to encrypt: %v", err)
+	}
+	if armorWriter != nil {
+		if err := armorWriter.Close(); err != nil {
+			t.Fatalf("failed to armor: %v", err)
+		}
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatalf("failed to write %s: %v", path, err)
+	}
+}
+
+// newIdentityFile generates an age identity and writes it to a file inside dir
+func newIdentityFile(t *testing.T, dir string) (*age.X25519Identity, string) {
+	t.Helper()
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("age.GenerateX25519Identity() error = %v", err)
+	}
+	path := filepath.Join(dir, "keys.txt")
+	if err := os.WriteFile(path, []byte("# test key\n"+identity.String()+"\n"), 0600); err != nil {
+		t.Fatalf("failed to write identity: %v", err)
+	}
+	return identity, path
+}
+
+func TestAgeDecryptor(t *testing.T) {
+	tmpDir := t.TempDir()
+	identity, identityFile := newIdentityFile(t, tmpDir)
+	other, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("age.GenerateX25519Identity() error = %v", err)
+	}
+
+	binary := filepath.Join(tmpDir, "binary.age")
+	writeEncrypted(t, binary, identity.Recipient(), "TOKEN=1\n", false)
+	armored := filepath.Join(tmpDir, "armored.age")
+	writeEncrypted(t, armored, identity.Recipient(), "TOKEN=2\n", true)
+	foreign := filepath.Join(tmpDir, "foreign.age")
+	writeEncrypted(t, foreign, other.Recipient(), "TOKEN=3\n", false)
+
+	tests := []struct {
+		name       string
+		identities []string
+		source     string
+		want       string
+		wantErr    bool
+	}{
+		{name: "binary", identities: []string{identityFile}, source: binary, want: "TOKEN=1\n"},
+		{name: "armored", identities: []string{identityFile}, source: armored, want: "TOKEN=2\n"},
+		{name: "encrypted to someone else", identities: []string{identityFile}, source: foreign, wantErr: true},
+		{name: "no identities", source: binary, wantErr: true},
+		{name: "missing identity file", identities: []string{filepath.Join(tmpDir, "gone.txt")}, source: binary, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &ageDecryptor{identityFiles: tt.identities}
+			got, err := d.decrypt(tt.source)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("decrypt() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if string(got) != tt.want {
+				t.Errorf("decrypt() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLinkerDecryptLifecycle(t *testing.T) {
+	tmpDir := t.TempDir()
+	linksDir := filepath.Join(tmpDir, "links")
+	worktree := filepath.Join(tmpDir, "worktree")
+	source := filepath.Join(linksDir, ".env.age")
+	for _, dir := range []string{linksDir, worktree} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("failed to create dir: %v", err)
+		}
+	}
+	identity, identityFile := newIdentityFile(t, tmpDir)
+	writeEncrypted(t, source, identity.Recipient(), "TOKEN=secret\n", false)
+
+	entries, err := markGenerated([]linkEntry{{source: source, target: ".env.age", mode: modeSymlink}})
+	if err != nil {
+		t.Fatalf("markGenerated() error = %v", err)
+	}
+	if entries[0].mode != modeDecrypt || entries[0].target != ".env" {
+		t.Fatalf("markGenerated() = %+v, want a decrypt entry for .env", entries[0])
+	}
+
+	l := &linker{
+		linksDirs: []string{linksDir},
+		records:   &linkRecords{Entries: map[string]linkRecord{}},
+		decryptor: &ageDecryptor{identityFiles: []string{identityFile}},
+	}
+	op := linkOp{entry: entries[0], worktree: worktree, target: filepath.Join(worktree, ".env")}
+
+	assertState := func(want linkState) {
+		t.Helper()
+		got, err := l.check(op)
+		if err != nil {
+			t.Fatalf("check() error = %v", err)
+		}
+		if got.State != want {
+			t.Fatalf("check() state = %v, want %v", got.State, want)
+		}
+	}
+	rewrite := func() {
+		t.Helper()
+		if err := os.Remove(op.target); err != nil {
+			t.Fatalf("failed to remove decrypted file: %v", err)
+		}
+		if err := l.materialize(op); err != nil {
+			t.Fatalf("materialize() error = %v", err)
+		}
+	}
+
+	assertState(stateMissing)
+	if err := l.materialize(op); err != nil {
+		t.Fatalf("materialize() error = %v", err)
+	}
+	assertState(stateLinked)
+
+	info, err := os.Lstat(op.target)
+	if err != nil {
+		t.Fatalf("failed to stat decrypted file: %v", err)
+	}
+	if !info.Mode().IsRegular() || info.Mode().Perm() != decryptedPerm {
+		t.Errorf("decrypted file mode = %v, want a regular file with %v", info.Mode(), decryptedPerm)
+	}
+	if content, err := os.ReadFile(op.target); err != nil || string(content) != "TOKEN=secret\n" {
+		t.Errorf("decrypted %q (%v), want %q", content, err, "TOKEN=secret\n")
+	}
+
+	// Readable by others: outdated, so it gets rewritten with the right permissions
+	if err := os.Chmod(op.target, 0644); err != nil {
+		t.Fatalf("failed to chmod: %v", err)
+	}
+	assertState(stateStale)
+	rewrite()
+	assertState(stateLinked)
+
+	// Source encrypted again with another secret: the next run finds the decrypted copy stale
+	writeEncrypted(t, source, identity.Recipient(), "TOKEN=rotated\n", false)
+	l = &linker{linksDirs: l.linksDirs, records: l.records, decryptor: &ageDecryptor{identityFiles: []string{identityFile}}}
+	assertState(stateStale)
+	rewrite()
+	assertState(stateLinked)
+
+	// Edited by hand: it is no longer ours to replace
+	if err := os.WriteFile(op.target, []byte("TOKEN=mine\n"), decryptedPerm); err != nil {
+		t.Fatalf("failed to update target: %v", err)
+	}
+	assertState(stateShadowed)
+}
+
+func TestDecryptRecordsKeepSecrets(t *testing.T) {
+	tmpDir := t.TempDir()
+	linksDir := filepath.Join(tmpDir, "links")
+	worktree := filepath.Join(tmpDir, "worktree")
+	source := filepath.Join(linksDir, ".env.age")
+	for _, dir := range []string{linksDir, worktree} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("failed to create dir: %v", err)
+		}
+	}
+	identity, identityFile := newIdentityFile(t, tmpDir)
+	writeEncrypted(t, source, identity.Recipient(), "TOKEN=1234\n", false)
+
+	recordsPath := filepath.Join(tmpDir, "toolbox", "links.json")
+	records, err := loadRecords(recordsPath)
+	if err != nil {
+		t.Fatalf("loadRecords() error = %v", err)
+	}
+	l := &linker{
+		linksDirs: []string{linksDir},
+		records:   records,
+		decryptor: &ageDecryptor{identityFiles: []string{identityFile}},
+	}
+	op := linkOp{entry: linkEntry{source: source, target: ".env", mode: modeDecrypt}, worktree: worktree, target: filepath.Join(worktree, ".env")}
+	if err := l.materialize(op); err != nil {
+		t.Fatalf("materialize() error = %v", err)
+	}
+	if err := records.save(); err != nil {
+		t.Fatalf("save() error = %v", err)
+	}
+
+	// The plaintext hash would let anyone reading the records guess the secret
+	if got := records.Entries[op.target].Hash; got == hashBytes([]byte("TOKEN=1234\n")) {
+		t.Errorf("record of %s holds the sha256 of the plaintext", op.target)
+	}
+	for _, path := range []string{recordsPath, filepath.Join(tmpDir, "toolbox", "links.key")} {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatalf("failed to stat %s: %v", path, err)
+		}
+		if perm := info.Mode().Perm(); perm != recordsPerm {
+			t.Errorf("%s has permissions %v, want %v", path, perm, recordsPerm)
+		}
+	}
+
+	// A later run with the saved key still recognizes its own decrypted file
+	reloaded, err := loadRecords(recordsPath)
+	if err != nil {
+		t.Fatalf("loadRecords() error = %v", err)
+	}
+	if ok, err := isRecorded(op.target, reloaded); err != nil || !ok {
+		t.Errorf("isRecorded() = %v, %v, want the decrypted file recognized", ok, err)
+	}
+}
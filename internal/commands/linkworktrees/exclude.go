@@ -0,0 +1,268 @@
+package linkworktrees
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/oscarteg/toolbox/internal/git"
+)
+
+// excludeComment explains the managed block to whoever opens info/exclude
+const excludeComment = "# Paths linked by 'toolbox linkworktrees', kept up to date on link and unlink"
+
+// excludePattern returns the info/exclude pattern matching exactly the target, relative to the worktree root
+func excludePattern(target string) string {
+	return "/" + filepath.ToSlash(target)
+}
+
+// readExcludeBlock returns the patterns in the managed block of an info/exclude file
+func readExcludeBlock(content string) []string {
+	start := strings.Index(content, managedBlockStart)
+	if start < 0 {
+		return nil
+	}
+	end := strings.Index(content[start:], managedBlockEnd)
+	if end < 0 {
+		return nil
+	}
+
+	var patterns []string
+	for _, line := range strings.Split(content[start+len(managedBlockStart):start+end], "\n") {
+		if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+			patterns = append(patterns, line)
+		}
+	}
+	return patterns
+}
+
+// writeExcludeBlock returns content with its managed block replaced by one listing patterns,
+// or without a managed block when there are no patterns
+func writeExcludeBlock(content string, patterns []string) string {
+	content, _ = removeManagedBlock(content)
+	if len(patterns) == 0 {
+		return content
+	}
+
+	if content != "" {
+		if !strings.HasSuffix(content, "\n") {
+			content += "\n"
+		}
+		content += "\n"
+	}
+	return content + managedBlockStart + "\n" + excludeComment + "\n" +
+		strings.Join(patterns, "\n") + "\n" + managedBlockEnd + "\n"
+}
+
+// updateExclude adds and removes patterns in the managed block of the info/exclude file at path
+func updateExclude(path string, add, remove []string) error {
+	if path == "" || (len(add) == 0 && len(remove) == 0) {
+		return nil
+	}
+
+	content, err := readExclude(path)
+	if err != nil {
+		return err
+	}
+
+	set := make(map[string]bool)
+	for _, p := range readExcludeBlock(content) {
+		set[p] = true
+	}
+	for _, p := range add {
+		set[p] = true
+	}
+	for _, p := range remove {
+		delete(set, p)
+	}
+	return writeExclude(path, content, slices.Collect(maps.Keys(set)))
+}
+
+// readExclude returns the content of the info/exclude file at path, empty when it does not exist yet
+func readExclude(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return "", fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return string(data), nil
+}
+
+// writeExclude replaces the managed block in content, read from the info/exclude file at path, by the sorted
+// patterns and writes it back when that changed anything
+func writeExclude(path, content string, patterns []string) error {
+	sort.Strings(patterns)
+	updated := writeExcludeBlock(content, patterns)
+	if updated == content {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create directory %s: %w", filepath.Dir(path), err)
+	}
+	if err := os.WriteFile(path, []byte(updated), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
+
+// rebuildExclude rebuilds the managed block of the info/exclude file at path, which is shared by the repositories
+// at roots. The targets of the patterns already in the block and the candidates are listed again only when they are
+// linked in at least one root and in the way of a link in none of them, so patterns of targets that were unlinked,
+// replaced by the user or linked differently since are dropped instead of hiding those files from git.
+func (l *linker) rebuildExclude(path string, roots, candidates []string, inTheWay map[string]bool) error {
+	if path == "" {
+		return nil
+	}
+
+	content, err := readExclude(path)
+	if err != nil {
+		return err
+	}
+
+	targets := make(map[string]bool)
+	for _, p := range readExcludeBlock(content) {
+		targets[filepath.FromSlash(strings.TrimPrefix(p, "/"))] = true
+	}
+	for _, target := range candidates {
+		targets[target] = true
+	}
+
+	var patterns []string
+	for target := range targets {
+		if inTheWay[target] {
+			continue
+		}
+		linked, err := l.isLinkedIn(roots, target)
+		if err != nil {
+			return err
+		}
+		if linked {
+			patterns = append(patterns, excludePattern(target))
+		}
+	}
+	return writeExclude(path, content, patterns)
+}
+
+// isLinkedIn reports whether target is managed by linkworktrees in at least one of roots, and nothing else
+// is at target in any of them
+func (l *linker) isLinkedIn(roots []string, target string) (bool, error) {
+	linked := false
+	for _, root := range roots {
+		path := filepath.Join(root, target)
+		if _, err := os.Lstat(path); os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return false, err
+		}
+
+		managed, err := l.isManaged(path)
+		if err != nil {
+			return false, err
+		}
+		if !managed {
+			return false, nil
+		}
+		linked = true
+	}
+	return linked, nil
+}
+
+// excludeLinked rebuilds info/exclude after linking, see rebuildExclude, with the linked targets as candidates and
+// leaving out the ones in the way of a link somewhere. Targets inside submodules go to the info/exclude of their
+// submodule, whose git directory belongs to that single checkout.
+func excludeLinked(ctx context.Context, opts linkOptions, l *linker, linked, inTheWay map[excludeTarget]bool) error {
+	if opts.excludePath == "" {
+		return nil
+	}
+
+	candidates := map[string][]string{"": nil}
+	skipped := make(map[string]map[string]bool)
+	for t := range linked {
+		candidates[t.repo] = append(candidates[t.repo], t.target)
+	}
+	for t := range inTheWay {
+		if _, ok := candidates[t.repo]; !ok {
+			candidates[t.repo] = nil
+		}
+		if skipped[t.repo] == nil {
+			skipped[t.repo] = make(map[string]bool)
+		}
+		skipped[t.repo][t.target] = true
+	}
+
+	for repo, targets := range candidates {
+		excludePath := opts.excludePath
+		roots := []string{repo}
+		var err error
+		if repo == "" {
+			roots, err = worktreeRoots(ctx, opts)
+		} else {
+			excludePath, err = git.GitPath(ctx, repo, "info/exclude")
+		}
+		if err != nil {
+			return err
+		}
+		if err := l.rebuildExclude(excludePath, roots, targets, skipped[repo]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pruneExclude rebuilds info/exclude after unlinking, see rebuildExclude. It is shared by all worktrees,
+// so the pattern of an unlinked target stays as long as any of them still has a link there.
+func pruneExclude(ctx context.Context, opts linkOptions, l *linker) error {
+	if opts.excludePath == "" {
+		return nil
+	}
+	roots, err := worktreeRoots(ctx, opts)
+	if err != nil {
+		return err
+	}
+	return l.rebuildExclude(opts.excludePath, roots, nil, nil)
+}
+
+// worktreeRoots returns the paths of the worktrees sharing opts.excludePath. The main worktree, which usually
+// holds the originals of the linked files, only counts when it is linked too.
+func worktreeRoots(ctx context.Context, opts linkOptions) ([]string, error) {
+	worktrees, err := git.ListWorktrees(ctx, opts.repo)
+	if err != nil {
+		return nil, err
+	}
+	var roots []string
+	for _, wt := range worktrees {
+		if wt.Bare || wt.Prunable || (wt.Main && !opts.worktrees.includeMain) {
+			continue
+		}
+		roots = append(roots, wt.Path)
+	}
+	return roots, nil
+}
+
+// isManaged reports whether path is a symlink into the links directory or an unmodified recorded copy
+func (l *linker) isManaged(path string) (bool, error) {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if info.Mode()&os.ModeSymlink != 0 {
+		dest, err := readLinkDest(path)
+		if err != nil {
+			return false, err
+		}
+		return isWithinAny(l.linksDirs, dest), nil
+	}
+	if !info.Mode().IsRegular() {
+		return false, nil
+	}
+	return isRecorded(path, l.records)
+}
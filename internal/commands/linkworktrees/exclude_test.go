@@ -0,0 +1,126 @@
+package linkworktrees
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestUpdateExclude(t *testing.T) {
+	block := func(patterns ...string) string {
+		return writeExcludeBlock("", patterns)
+	}
+
+	tests := []struct {
+		name     string
+		existing string
+		add      []string
+		remove   []string
+		want     string
+	}{
+		{
+			name:     "new file",
+			existing: "",
+			add:      []string{"/.env", "/.vscode/settings.json"},
+			want:     block("/.env", "/.vscode/settings.json"),
+		},
+		{
+			name:     "keeps user patterns",
+			existing: "# git ls-files --others --exclude-from=.git/info/exclude\n*.log",
+			add:      []string{"/.env"},
+			want:     "# git ls-files --others --exclude-from=.git/info/exclude\n*.log\n\n" + block("/.env"),
+		},
+		{
+			name:     "merges and sorts",
+			existing: "*.log\n\n" + block("/b"),
+			add:      []string{"/c", "/a", "/b"},
+			want:     "*.log\n\n" + block("/a", "/b", "/c"),
+		},
+		{
+			name:     "removes patterns",
+			existing: "*.log\n\n" + block("/a", "/b"),
+			remove:   []string{"/a"},
+			want:     "*.log\n\n" + block("/b"),
+		},
+		{
+			name:     "drops the empty block",
+			existing: "*.log\n\n" + block("/a"),
+			remove:   []string{"/a"},
+			want:     "*.log\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "info", "exclude")
+			if tt.existing != "" {
+				if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+					t.Fatalf("failed to create dir: %v", err)
+				}
+				if err := os.WriteFile(path, []byte(tt.existing), 0644); err != nil {
+					t.Fatalf("failed to write exclude file: %v", err)
+				}
+			}
+
+			if err := updateExclude(path, tt.add, tt.remove); err != nil {
+				t.Fatalf("updateExclude() error = %v", err)
+			}
+			got, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("failed to read exclude file: %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("updateExclude() =\n%q\nwant\n%q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadExcludeBlock(t *testing.T) {
+	content := "*.log\n\n" + writeExcludeBlock("", []string{"/.env", "/config/local.yml"}) + "/tmp\n"
+	want := []string{"/.env", "/config/local.yml"}
+	if got := readExcludeBlock(content); !reflect.DeepEqual(got, want) {
+		t.Errorf("readExcludeBlock() = %v, want %v", got, want)
+	}
+	if got := readExcludeBlock("*.log\n"); got != nil {
+		t.Errorf("readExcludeBlock() without block = %v, want nil", got)
+	}
+}
+
+func TestRebuildExclude(t *testing.T) {
+	tmpDir := t.TempDir()
+	linksDir := filepath.Join(tmpDir, "links")
+	wt1, wt2 := filepath.Join(tmpDir, "wt1"), filepath.Join(tmpDir, "wt2")
+	writeFiles(t, linksDir, map[string]string{"a.txt": "a", "b.txt": "b", "c.txt": "c", "d.txt": "d", ".claude/settings.json": "{}"})
+	writeFiles(t, wt2, map[string]string{"cfg/a.txt": "mine", ".claude/settings.json": "{}"})
+	for _, target := range []string{"cfg/a.txt", "b.txt", "c.txt", "d.txt"} {
+		link := filepath.Join(wt1, target)
+		if err := os.MkdirAll(filepath.Dir(link), 0755); err != nil {
+			t.Fatalf("failed to create dir: %v", err)
+		}
+		if err := os.Symlink(filepath.Join(linksDir, filepath.Base(target)), link); err != nil {
+			t.Fatalf("failed to create symlink: %v", err)
+		}
+	}
+
+	path := filepath.Join(tmpDir, "info", "exclude")
+	existing := "*.log\n\n" + writeExcludeBlock("", []string{"/.claude", "/b.txt", "/cfg/a.txt", "/gone.txt"})
+	writeFiles(t, filepath.Dir(path), map[string]string{"exclude": existing})
+
+	l := &linker{linksDirs: []string{linksDir}, records: &linkRecords{Entries: map[string]linkRecord{}}}
+	err := l.rebuildExclude(path, []string{wt1, wt2}, []string{"c.txt", "d.txt"}, map[string]bool{"d.txt": true})
+	if err != nil {
+		t.Fatalf("rebuildExclude() error = %v", err)
+	}
+
+	// A real file or directory in any worktree, a target that is gone and a target skipped
+	// because it is in the way all lose their pattern, so git keeps showing those files
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read exclude file: %v", err)
+	}
+	if want := "*.log\n\n" + writeExcludeBlock("", []string{"/b.txt", "/c.txt"}); string(got) != want {
+		t.Errorf("rebuildExclude() =\n%q\nwant\n%q", got, want)
+	}
+}
@@ -4,240 +4,201 @@ import (
 	"context"
 	"fmt"
 	"os"
-	"os/exec"
+	"os/signal"
 	"path/filepath"
 	"strings"
+	"syscall"
 
+	"github.com/oscarteg/toolbox/internal/git"
 	"github.com/urfave/cli/v3"
 )
 
 // linkOptions holds configuration for the link operation
 type linkOptions struct {
-	linksDir string
-	dryRun   bool
-	verbose  bool
+	linksDirs         []string // links directories overlaid in order, later ones overriding earlier ones
+	manifest          string
+	dirs              []string
+	dirsSet           bool
+	mode              linkMode
+	modeSet           bool
+	onConflict        conflictPolicy
+	recordsPath       string
+	journalPath       string // journal of the link run in progress, see transaction
+	excludePath       string // info/exclude file listing linked paths, shared by all worktrees
+	repo              string // directory inside the repository to operate on, empty for the current directory
+	root              string // main worktree holding relative links directories and the manifest, empty for the current directory
+	worktrees         worktreeFilter
+	targets           []string // target directories of the link command, replacing the worktrees when set
+	identities        []string // age identity files .age sources are decrypted with
+	recurseSubmodules bool     // link the links/<submodule path> subsets into checked out submodules, see routeSubmodules
+	jobs              int      // worktrees checked and linked concurrently
+	dryRun            bool
+	planFormat        string // json or ndjson prints the dry run as a plan for apply --plan, empty for text
+	verbose           bool
 }
 
-func handleLinkWorktrees(ctx context.Context, cmd *cli.Command) error {
+// newLinkOptions reads the options shared by linkworktrees, link and their subcommands
+func newLinkOptions(ctx context.Context, cmd *cli.Command) (linkOptions, error) {
 	opts := linkOptions{
-		linksDir: cmd.String("links-dir"),
-		dryRun:   cmd.Bool("dry-run"),
-		verbose:  cmd.Root().Bool("verbose"),
+		manifest: cmd.String("manifest"),
+		dirs:     cmd.StringSlice("dir"),
+		dirsSet:  cmd.IsSet("dir"),
+		mode:     modeSymlink,
+		worktrees: worktreeFilter{
+			includeMain:   cmd.Bool("include-main"),
+			includeLocked: cmd.Bool("include-locked"),
+			branches:      cmd.StringSlice("branch"),
+			excludes:      cmd.StringSlice("exclude"),
+		},
+		recurseSubmodules: cmd.Bool("recurse-submodules"),
+		jobs:              cmd.Int("jobs"),
+		dryRun:            cmd.Bool("dry-run"),
+		verbose:           cmd.Root().Bool("verbose"),
 	}
 
-	if opts.dryRun {
-		fmt.Println("DRY RUN: No changes will be made")
-		fmt.Println()
+	for _, p := range cmd.StringSlice("worktree") {
+		abs, err := filepath.Abs(p)
+		if err != nil {
+			return opts, fmt.Errorf("failed to resolve worktree %s: %w", p, err)
+		}
+		opts.worktrees.paths = append(opts.worktrees.paths, abs)
 	}
 
-	// Check if links directory exists
-	if _, err := os.Stat(opts.linksDir); os.IsNotExist(err) {
-		return fmt.Errorf("error: '%s' directory not found in current directory", opts.linksDir)
+	if m := cmd.String("mode"); m != "" {
+		mode, err := parseLinkMode(m)
+		if err != nil {
+			return opts, err
+		}
+		opts.mode = mode
+		opts.modeSet = cmd.IsSet("mode")
 	}
 
-	// Get all worktree paths (excluding the main worktree)
-	worktrees, err := getWorktrees(ctx)
-	if err != nil {
-		return fmt.Errorf("failed to get worktrees: %w", err)
+	if cmd.Bool("relative") {
+		if opts.modeSet && !opts.mode.isSymlink() {
+			return opts, fmt.Errorf("--relative cannot be combined with --mode %s", opts.mode)
+		}
+		opts.mode = modeRelativeSymlink
+		opts.modeSet = true
 	}
 
-	if len(worktrees) == 0 {
-		fmt.Println("No additional worktrees found")
-		return nil
+	var err error
+	if repo := cmd.String("repo"); repo != "" {
+		if opts.repo, err = filepath.Abs(repo); err != nil {
+			return opts, fmt.Errorf("failed to resolve repository %s: %w", repo, err)
+		}
 	}
-
-	fmt.Println("Found worktrees:")
-	for _, worktree := range worktrees {
-		fmt.Printf("  %s\n", worktree)
+	// The link command resolves its paths against the current directory, it is not bound to a repository
+	if !hasFlag(cmd, "to") {
+		if opts.root, err = git.MainWorktree(ctx, opts.repo); err != nil {
+			return opts, fmt.Errorf("failed to find the main worktree: %w", err)
+		}
 	}
-	fmt.Println()
 
-	// Handle special directory exceptions first (.claude)
-	specialDirs := []string{".claude"}
-	if err := linkSpecialDirs(ctx, opts, worktrees, specialDirs); err != nil {
-		return err
+	if opts.linksDirs, err = opts.resolveLinksDirs(ctx, cmd.StringSlice("links-dir")); err != nil {
+		return opts, err
+	}
+	if opts.identities, err = opts.resolveIdentities(ctx, cmd.StringSlice("identity")); err != nil {
+		return opts, err
 	}
 
-	// Find all files in links directory recursively, excluding special directories
-	linkFiles, err := findLinkFiles(opts.linksDir, specialDirs)
-	if err != nil {
-		return fmt.Errorf("failed to find link files: %w", err)
+	if hasFlag(cmd, "to") {
+		if opts.targets, err = resolveTargets(ctx, cmd.StringSlice("to"), opts.manifest); err != nil {
+			return opts, err
+		}
 	}
 
-	if len(linkFiles) == 0 {
-		fmt.Printf("No files found in '%s' directory (excluding special directories)\n", opts.linksDir)
-		return nil
+	stateDir, err := opts.stateDir(ctx)
+	if err != nil {
+		return opts, err
 	}
+	opts.recordsPath = filepath.Join(stateDir, recordsFileName)
+	opts.journalPath = filepath.Join(stateDir, journalFileName)
 
-	// Process each file
-	if err := linkFiles_(ctx, opts, worktrees, linkFiles); err != nil {
-		return err
+	// Target directories are not worktrees, git does not look at info/exclude for them
+	if opts.targets == nil {
+		if opts.excludePath, err = git.GitPath(ctx, opts.repo, "info/exclude"); err != nil {
+			return opts, err
+		}
 	}
 
-	fmt.Println("Symlink operation completed")
-	return nil
+	return opts, nil
 }
 
-// linkSpecialDirs links special directories (like .claude) as complete directories
-func linkSpecialDirs(ctx context.Context, opts linkOptions, worktrees, specialDirs []string) error {
-	for _, specialDir := range specialDirs {
-		// Check for context cancellation
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		default:
-		}
-
-		specialDirPath := filepath.Join(opts.linksDir, specialDir)
-		if _, err := os.Stat(specialDirPath); err != nil {
-			continue // Directory doesn't exist, skip
-		}
+func handleLinkWorktrees(ctx context.Context, cmd *cli.Command) error {
+	opts, err := newLinkOptions(ctx, cmd)
+	if err != nil {
+		return err
+	}
 
-		sourcePath, err := filepath.Abs(specialDirPath)
-		if err != nil {
-			return fmt.Errorf("failed to get absolute path for %s: %w", specialDirPath, err)
-		}
+	policy, err := parseConflictPolicy(cmd.String("on-conflict"))
+	if err != nil {
+		return err
+	}
+	opts.onConflict = policy
 
-		fmt.Printf("Linking directory %s to all worktrees...\n", specialDir)
+	if opts.planFormat, err = parsePlanFormat(cmd.String("format")); err != nil {
+		return err
+	}
+	if opts.planFormat != "" && !opts.dryRun {
+		return fmt.Errorf("--format %s requires --dry-run", opts.planFormat)
+	}
 
-		for _, worktree := range worktrees {
-			targetPath := filepath.Join(worktree, specialDir)
-
-			if opts.dryRun {
-				fmt.Printf("  Would link: %s -> %s\n", sourcePath, targetPath)
-				continue
-			}
-
-			// Remove existing directory/symlink if it exists
-			if _, err := os.Lstat(targetPath); err == nil {
-				if err := os.RemoveAll(targetPath); err != nil {
-					return fmt.Errorf("failed to remove existing %s: %w", targetPath, err)
-				}
-			}
-
-			if err := os.Symlink(sourcePath, targetPath); err != nil {
-				return fmt.Errorf("failed to create symlink %s -> %s: %w", sourcePath, targetPath, err)
-			}
-			fmt.Printf("  -> %s\n", targetPath)
-		}
+	if opts.dryRun && opts.planFormat == "" {
+		fmt.Println("DRY RUN: No changes will be made")
 		fmt.Println()
 	}
-	return nil
-}
 
-// linkFiles_ links individual files to worktrees
-func linkFiles_(ctx context.Context, opts linkOptions, worktrees, files []string) error {
-	for _, file := range files {
-		// Check for context cancellation
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		default:
-		}
+	// Resolve and validate everything to link before touching any worktree
+	entries, err := collectEntries(opts)
+	if err != nil {
+		return err
+	}
 
-		relPath, err := filepath.Rel(opts.linksDir, file)
-		if err != nil {
-			return fmt.Errorf("failed to get relative path for %s: %w", file, err)
-		}
+	if len(entries) == 0 && opts.planFormat == "" {
+		fmt.Printf("No files found in '%s'\n", strings.Join(opts.linksDirs, "', '"))
+		return nil
+	}
 
-		sourcePath, err := filepath.Abs(file)
-		if err != nil {
-			return fmt.Errorf("failed to get absolute path for %s: %w", file, err)
-		}
+	// Get the selected worktree paths (excluding the main worktree unless asked for)
+	worktrees, err := opts.selectTargets(ctx)
+	if err != nil {
+		return err
+	}
 
-		fmt.Printf("Linking %s to all worktrees...\n", relPath)
+	if opts.planFormat == "" {
+		if len(worktrees) == 0 {
+			fmt.Printf("No matching %s found\n", opts.targetNoun())
+			return nil
+		}
 
+		fmt.Printf("Found %s:\n", opts.targetNoun())
 		for _, worktree := range worktrees {
-			targetPath := filepath.Join(worktree, relPath)
-			targetDir := filepath.Dir(targetPath)
-
-			if opts.dryRun {
-				fmt.Printf("  Would link: %s -> %s\n", sourcePath, targetPath)
-				continue
-			}
-
-			if err := os.MkdirAll(targetDir, 0755); err != nil {
-				return fmt.Errorf("failed to create directory %s: %w", targetDir, err)
-			}
-
-			// Remove existing file/symlink if it exists
-			if _, err := os.Lstat(targetPath); err == nil {
-				if err := os.Remove(targetPath); err != nil {
-					return fmt.Errorf("failed to remove existing %s: %w", targetPath, err)
-				}
-			}
-
-			if err := os.Symlink(sourcePath, targetPath); err != nil {
-				return fmt.Errorf("failed to create symlink %s -> %s: %w", sourcePath, targetPath, err)
-			}
-			fmt.Printf("  -> %s\n", targetPath)
+			fmt.Printf("  %s\n", worktree)
 		}
 		fmt.Println()
 	}
-	return nil
-}
 
-func getWorktrees(ctx context.Context) ([]string, error) {
-	cmd := exec.CommandContext(ctx, "git", "worktree", "list", "--porcelain")
-	output, err := cmd.Output()
+	// Interrupting rolls back what was linked so far instead of leaving worktrees half linked
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	ops, err := planTargets(ctx, opts, entries, worktrees)
 	if err != nil {
-		return nil, fmt.Errorf("failed to execute git worktree list: %w", err)
-	}
-
-	lines := strings.Split(string(output), "\n")
-	worktrees := make([]string, 0, len(lines)/4) // Pre-allocate, ~4 lines per worktree
-	first := true
-
-	for _, line := range lines {
-		if strings.HasPrefix(line, "worktree ") {
-			if first {
-				// Skip the first worktree (main worktree)
-				first = false
-				continue
-			}
-			worktree := strings.TrimPrefix(line, "worktree ")
-			if worktree != "" {
-				worktrees = append(worktrees, worktree)
-			}
-		}
+		return err
+	}
+	if err := applyLinks(ctx, opts, ops); err != nil {
+		return err
 	}
 
-	return worktrees, nil
+	if opts.planFormat == "" {
+		fmt.Println("Symlink operation completed")
+	}
+	return nil
 }
 
+// findLinkFiles returns the individual files in linksDir, skipping excluded directories and
+// what the default ignore patterns or a .linkignore ignore
 func findLinkFiles(linksDir string, excludeDirs []string) ([]string, error) {
-	var files []string
-
-	err := filepath.Walk(linksDir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-
-		// Skip directories
-		if info.IsDir() {
-			return nil
-		}
-
-		// Skip hidden files (files starting with .)
-		if strings.HasPrefix(info.Name(), ".") {
-			return nil
-		}
-
-		// Check if file is in an excluded directory
-		relPath, err := filepath.Rel(linksDir, path)
-		if err != nil {
-			return err
-		}
-
-		for _, excludeDir := range excludeDirs {
-			if strings.HasPrefix(relPath, excludeDir+string(filepath.Separator)) {
-				return nil
-			}
-		}
-
-		files = append(files, path)
-		return nil
-	})
-
+	_, files, err := scanLinksDir(linksDir, excludeDirs, defaultIgnore)
 	return files, err
 }
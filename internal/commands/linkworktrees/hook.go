@@ -0,0 +1,227 @@
+package linkworktrees
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/oscarteg/toolbox/internal/git"
+	"github.com/urfave/cli/v3"
+)
+
+const (
+	hookName = "post-checkout"
+
+	// managedBlockStart and managedBlockEnd delimit the lines linkworktrees maintains in files it shares with others
+	managedBlockStart = "# >>> toolbox linkworktrees >>>"
+	managedBlockEnd   = "# <<< toolbox linkworktrees <<<"
+)
+
+// hookScript runs from the root of the checked out worktree. git worktree add passes the null
+// object id as the previous HEAD, which is what tells a fresh worktree apart from a branch switch.
+// Relative links directories are resolved against the main worktree, and the hook does nothing in
+// repositories without them, so it keeps working when shared through core.hooksPath.
+// %[1]s tests whether there is anything to link, %[2]s are the --links-dir flags.
+const hookScript = `# Managed by 'toolbox lw install-hook', remove with 'toolbox lw uninstall-hook'
+case "$1" in
+*[!0]*) ;;
+*)
+	toolbox_worktree=$(pwd)
+	toolbox_main=$(git worktree list --porcelain | sed -n '1s/^worktree //p')
+	if { %[1]s; } && command -v toolbox >/dev/null 2>&1; then
+		(
+			unset GIT_DIR GIT_WORK_TREE GIT_INDEX_FILE
+			cd "$toolbox_main" && toolbox linkworktrees%[2]s --worktree "$toolbox_worktree"
+		) || echo "toolbox: failed to link $toolbox_worktree" >&2
+	fi
+	;;
+esac
+`
+
+// handleInstallHook adds the linkworktrees block to the post-checkout hook, keeping anything else in it
+func handleInstallHook(ctx context.Context, cmd *cli.Command) error {
+	hooksDir, err := git.HooksDir(ctx, cmd.String("repo"))
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(hooksDir, hookName)
+
+	existing, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	content, err := addHookBlock(string(existing), hookBlock(cmd.StringSlice("links-dir")))
+	if err != nil {
+		return fmt.Errorf("cannot install into %s: %w", path, err)
+	}
+
+	if err := os.MkdirAll(hooksDir, 0755); err != nil {
+		return fmt.Errorf("failed to create directory %s: %w", hooksDir, err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0755); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	// WriteFile keeps the mode of an existing file
+	if err := os.Chmod(path, 0755); err != nil {
+		return err
+	}
+
+	fmt.Printf("Installed %s hook in %s\n", hookName, path)
+	return nil
+}
+
+// handleUninstallHook removes the linkworktrees block from the post-checkout hook, and the hook itself if nothing else is left
+func handleUninstallHook(ctx context.Context, cmd *cli.Command) error {
+	hooksDir, err := git.HooksDir(ctx, cmd.String("repo"))
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(hooksDir, hookName)
+
+	existing, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		fmt.Printf("No %s hook installed in %s\n", hookName, hooksDir)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	content, found := removeManagedBlock(string(existing))
+	if !found {
+		fmt.Printf("No linkworktrees block found in %s\n", path)
+		return nil
+	}
+
+	if isEmptyHook(content) {
+		if err := os.Remove(path); err != nil {
+			return fmt.Errorf("failed to remove %s: %w", path, err)
+		}
+		fmt.Printf("Removed %s\n", path)
+		return nil
+	}
+
+	if err := os.WriteFile(path, []byte(content), 0755); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	fmt.Printf("Removed linkworktrees block from %s\n", path)
+	return nil
+}
+
+// hookBlock returns the managed block linking fresh worktrees from linksDirs. Without any, the
+// links directories are resolved when the hook runs, from the manifest or the default.
+func hookBlock(linksDirs []string) string {
+	paths := linksDirs
+	if len(paths) == 0 {
+		paths = append([]string{defaultLinksDir}, manifestNames...)
+	}
+
+	var tests []string
+	for _, p := range paths {
+		if filepath.IsAbs(p) {
+			tests = append(tests, "[ -e "+shellQuote(p)+" ]")
+		} else {
+			tests = append(tests, `[ -e "$toolbox_main/"`+shellQuote(p)+" ]")
+		}
+	}
+	var flags strings.Builder
+	for _, dir := range linksDirs {
+		flags.WriteString(" --links-dir " + shellQuote(dir))
+	}
+
+	script := fmt.Sprintf(hookScript, strings.Join(tests, " || "), flags.String())
+	return managedBlockStart + "\n" + script + managedBlockEnd + "\n"
+}
+
+// addHookBlock returns hook with block added right after its shebang, replacing a block installed earlier.
+// Coming first, the block also runs in hooks that end with exit or exec.
+func addHookBlock(hook, block string) (string, error) {
+	if hook == "" {
+		return "#!/bin/sh\n\n" + block, nil
+	}
+
+	if shebang, _, _ := strings.Cut(hook, "\n"); strings.HasPrefix(shebang, "#!") && !isShellShebang(shebang) {
+		return "", fmt.Errorf("hook is not a shell script (%s), add a call to 'toolbox lw --worktree <path>' to it yourself", shebang)
+	}
+
+	if rest, found := removeManagedBlock(hook); found {
+		hook = rest
+	}
+
+	var head, body string
+	if strings.HasPrefix(hook, "#!") {
+		shebang, rest, _ := strings.Cut(hook, "\n")
+		head, body = shebang+"\n\n", rest
+	} else {
+		body = hook
+	}
+	if body = strings.TrimLeft(body, "\n"); body == "" {
+		return head + block, nil
+	}
+	if !strings.HasSuffix(body, "\n") {
+		body += "\n"
+	}
+	return head + block + "\n" + body, nil
+}
+
+// removeManagedBlock returns content without the managed block, and whether there was one
+func removeManagedBlock(content string) (string, bool) {
+	start := strings.Index(content, managedBlockStart)
+	if start < 0 {
+		return content, false
+	}
+	end := strings.Index(content[start:], managedBlockEnd)
+	if end < 0 {
+		return content, false
+	}
+	end += start + len(managedBlockEnd)
+	if end < len(content) && content[end] == '\n' {
+		end++
+	}
+
+	before := strings.TrimRight(content[:start], "\n")
+	if before != "" {
+		before += "\n"
+	}
+	after := content[end:]
+	if before != "" && after != "" {
+		before += "\n"
+	}
+	return before + strings.TrimLeft(after, "\n"), true
+}
+
+// isEmptyHook reports whether the hook holds nothing but a shebang and blank lines
+func isEmptyHook(hook string) bool {
+	for _, line := range strings.Split(hook, "\n") {
+		if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#!") {
+			return false
+		}
+	}
+	return true
+}
+
+// isShellShebang reports whether the shebang runs a POSIX compatible shell
+func isShellShebang(shebang string) bool {
+	fields := strings.Fields(strings.TrimPrefix(shebang, "#!"))
+	if len(fields) == 0 {
+		return false
+	}
+	interpreter := filepath.Base(fields[0])
+	if interpreter == "env" && len(fields) > 1 {
+		interpreter = fields[1]
+	}
+	switch interpreter {
+	case "sh", "bash", "dash", "ksh", "zsh":
+		return true
+	default:
+		return false
+	}
+}
+
+// shellQuote quotes s for use as a single word in a POSIX shell script
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
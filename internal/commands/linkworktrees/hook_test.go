@@ -0,0 +1,140 @@
+package linkworktrees
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAddHookBlock(t *testing.T) {
+	block := hookBlock([]string{"links"})
+
+	tests := []struct {
+		name    string
+		hook    string
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "new hook",
+			hook: "",
+			want: "#!/bin/sh\n\n" + block,
+		},
+		{
+			name: "existing shell hook",
+			hook: "#!/bin/bash\necho hi\n",
+			want: "#!/bin/bash\n\n" + block + "\necho hi\n",
+		},
+		{
+			name: "existing hook without trailing newline",
+			hook: "#!/usr/bin/env sh\necho hi",
+			want: "#!/usr/bin/env sh\n\n" + block + "\necho hi\n",
+		},
+		{
+			name: "existing hook ending in exit",
+			hook: "#!/bin/sh\nset -e\nmake hooks\nexit 0\n",
+			want: "#!/bin/sh\n\n" + block + "\nset -e\nmake hooks\nexit 0\n",
+		},
+		{
+			name: "existing hook without shebang",
+			hook: "echo hi\n",
+			want: block + "\necho hi\n",
+		},
+		{
+			name: "replaces earlier block",
+			hook: "#!/bin/sh\necho hi\n\n" + hookBlock([]string{"old"}) + "\necho bye\n",
+			want: "#!/bin/sh\n\n" + block + "\necho hi\n\necho bye\n",
+		},
+		{
+			name: "reinstall",
+			hook: "#!/bin/sh\n\n" + hookBlock([]string{"old"}) + "\necho hi\n",
+			want: "#!/bin/sh\n\n" + block + "\necho hi\n",
+		},
+		{
+			name:    "not a shell script",
+			hook:    "#!/usr/bin/env python3\nprint('hi')\n",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := addHookBlock(tt.hook, block)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("addHookBlock() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("addHookBlock() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRemoveManagedBlock(t *testing.T) {
+	tests := []struct {
+		name      string
+		hook      string
+		want      string
+		wantFound bool
+		wantEmpty bool
+	}{
+		{
+			name:      "only our block",
+			hook:      "#!/bin/sh\n\n" + hookBlock([]string{"links"}),
+			want:      "#!/bin/sh\n",
+			wantFound: true,
+			wantEmpty: true,
+		},
+		{
+			name:      "keeps the rest of the hook",
+			hook:      "#!/bin/bash\necho hi\n\n" + hookBlock([]string{"links"}),
+			want:      "#!/bin/bash\necho hi\n",
+			wantFound: true,
+		},
+		{
+			name:      "block in the middle",
+			hook:      "#!/bin/sh\necho hi\n\n" + hookBlock([]string{"links"}) + "\necho bye\n",
+			want:      "#!/bin/sh\necho hi\n\necho bye\n",
+			wantFound: true,
+		},
+		{
+			name: "no block",
+			hook: "#!/bin/sh\necho hi\n",
+			want: "#!/bin/sh\necho hi\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, found := removeManagedBlock(tt.hook)
+			if found != tt.wantFound {
+				t.Errorf("removeManagedBlock() found = %v, want %v", found, tt.wantFound)
+			}
+			if got != tt.want {
+				t.Errorf("removeManagedBlock() =\n%q\nwant\n%q", got, tt.want)
+			}
+			if isEmptyHook(got) != tt.wantEmpty {
+				t.Errorf("isEmptyHook() = %v, want %v", !tt.wantEmpty, tt.wantEmpty)
+			}
+		})
+	}
+}
+
+func TestHookBlockQuotesLinksDir(t *testing.T) {
+	block := hookBlock([]string{"it's links", "/home/me/links"})
+	if !strings.Contains(block, `--links-dir 'it'\''s links' --links-dir '/home/me/links'`) {
+		t.Errorf("hookBlock() does not quote the links directories:\n%s", block)
+	}
+	if !strings.Contains(block, `[ -e "$toolbox_main/"'it'\''s links' ] || [ -e '/home/me/links' ]`) {
+		t.Errorf("hookBlock() does not test for the links directories:\n%s", block)
+	}
+}
+
+func TestHookBlockWithoutLinksDir(t *testing.T) {
+	block := hookBlock(nil)
+	if strings.Contains(block, "--links-dir") {
+		t.Errorf("hookBlock() passes --links-dir without any given:\n%s", block)
+	}
+	if !strings.Contains(block, `[ -e "$toolbox_main/"'links' ] || [ -e "$toolbox_main/"'toolbox.links.toml' ]`) {
+		t.Errorf("hookBlock() does not test for the links directory or a manifest:\n%s", block)
+	}
+}
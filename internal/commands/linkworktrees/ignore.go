@@ -0,0 +1,196 @@
+package linkworktrees
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// ignoreFileName is the gitignore-syntax file listing what not to link, in any directory of the links directory
+const ignoreFileName = ".linkignore"
+
+// defaultIgnore skips hidden files but not hidden directories, templates like .env.tmpl or encrypted files like
+// .env.age, unless the manifest configures other patterns. A .linkignore can re-include hidden files with e.g. !.envrc.
+var defaultIgnore = []string{".*", "!.*/", "!.*" + templateExt, "!.*" + encryptedExt}
+
+// defaultTargetIgnore replaces defaultIgnore for the link command, whose dotfiles are mostly hidden. It only skips
+// the git metadata of a links directory that is a repository of its own.
+var defaultTargetIgnore = []string{".git"}
+
+// ignoreRule is a single compiled gitignore pattern
+type ignoreRule struct {
+	base    string // slash separated directory of the file the pattern came from, relative to the links directory
+	re      *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+// ignoreMatcher evaluates the default patterns and the .linkignore files found during a walk
+type ignoreMatcher struct {
+	rules []ignoreRule
+}
+
+// newIgnoreMatcher returns a matcher for the given patterns, which apply to the whole links directory
+func newIgnoreMatcher(patterns []string) (*ignoreMatcher, error) {
+	m := &ignoreMatcher{}
+	for _, p := range patterns {
+		if err := m.add(p, "."); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// checkIgnorePatterns rejects malformed ignore patterns
+func checkIgnorePatterns(patterns []string) error {
+	_, err := newIgnoreMatcher(patterns)
+	return err
+}
+
+// add compiles one line of gitignore syntax relative to base, skipping blank lines and comments
+func (m *ignoreMatcher) add(line, base string) error {
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return nil
+	}
+
+	rule := ignoreRule{base: filepath.ToSlash(base)}
+	if strings.HasPrefix(line, "!") {
+		rule.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\!`) || strings.HasPrefix(line, `\#`) {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly = true
+		line = strings.TrimSuffix(line, "/")
+	}
+	if line == "" {
+		return nil
+	}
+
+	re, err := compileIgnorePattern(line)
+	if err != nil {
+		return fmt.Errorf("invalid ignore pattern %q: %w", line, err)
+	}
+	rule.re = re
+	m.rules = append(m.rules, rule)
+	return nil
+}
+
+// addFile adds the patterns of the .linkignore in dir, if there is one. rel is dir relative to the links directory.
+func (m *ignoreMatcher) addFile(dir, rel string) error {
+	f, err := os.Open(filepath.Join(dir, ignoreFileName))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if err := m.add(scanner.Text(), rel); err != nil {
+			return fmt.Errorf("%s: %w", filepath.Join(dir, ignoreFileName), err)
+		}
+	}
+	return scanner.Err()
+}
+
+// ignored reports whether rel, relative to the links directory, is ignored. The last matching pattern wins.
+func (m *ignoreMatcher) ignored(rel string, isDir bool) bool {
+	rel = filepath.ToSlash(rel)
+	ignored := false
+
+	for _, rule := range m.rules {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		p := rel
+		if rule.base != "." {
+			if !strings.HasPrefix(rel, rule.base+"/") {
+				continue
+			}
+			p = strings.TrimPrefix(rel, rule.base+"/")
+		}
+		if rule.re.MatchString(p) {
+			ignored = !rule.negate
+		}
+	}
+	return ignored
+}
+
+// compileIgnorePattern translates a gitignore pattern into a regular expression matching slash separated paths.
+// Patterns without a slash match a name at any depth, others are anchored to the directory of the ignore file.
+func compileIgnorePattern(pattern string) (*regexp.Regexp, error) {
+	anchored := strings.Contains(pattern, "/")
+	pattern = strings.TrimPrefix(pattern, "/")
+
+	var b strings.Builder
+	b.WriteString("^")
+	if !anchored {
+		b.WriteString("(?:.*/)?")
+	}
+
+	segments := strings.Split(pattern, "/")
+	for i, segment := range segments {
+		last := i == len(segments)-1
+		if segment == "**" {
+			if last {
+				b.WriteString(".*")
+			} else {
+				b.WriteString("(?:.*/)?")
+			}
+			continue
+		}
+
+		if _, err := path.Match(segment, ""); err != nil {
+			return nil, err
+		}
+		b.WriteString(globToRegexp(segment))
+		if !last {
+			b.WriteString("/")
+		}
+	}
+	b.WriteString("$")
+
+	return regexp.Compile(b.String())
+}
+
+// globToRegexp translates a single path segment glob into a regular expression
+func globToRegexp(glob string) string {
+	var b strings.Builder
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			b.WriteString("[^/]*")
+		case '?':
+			b.WriteString("[^/]")
+		case '\\':
+			if i+1 < len(glob) {
+				i++
+				b.WriteString(regexp.QuoteMeta(string(glob[i])))
+			}
+		case '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return b.String()
+}
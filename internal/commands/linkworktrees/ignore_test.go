@@ -0,0 +1,120 @@
+package linkworktrees
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestIgnoreMatcher(t *testing.T) {
+	tests := []struct {
+		name     string
+		patterns []string
+		path     string
+		isDir    bool
+		want     bool
+	}{
+		{"default skips hidden files", defaultIgnore, ".hidden", false, true},
+		{"default skips nested hidden files", defaultIgnore, "sub/.DS_Store", false, true},
+		{"default keeps hidden directories", defaultIgnore, ".claude", true, false},
+		{"default keeps regular files", defaultIgnore, "README.md", false, false},
+		{"negation re-includes", append(slices.Clone(defaultIgnore), "!.envrc"), ".envrc", false, false},
+		{"last match wins", []string{"*.md", "!README.md", "README.md"}, "README.md", false, true},
+		{"name matches at any depth", []string{"*.swp"}, "sub/dir/file.swp", false, true},
+		{"anchored pattern", []string{"/notes.txt"}, "sub/notes.txt", false, false},
+		{"anchored pattern at root", []string{"/notes.txt"}, "notes.txt", false, true},
+		{"pattern with slash is anchored", []string{"sub/*.txt"}, "other/sub/a.txt", false, false},
+		{"directory only pattern skips files", []string{"build/"}, "build", false, false},
+		{"directory only pattern", []string{"build/"}, "build", true, true},
+		{"leading double star", []string{"**/cache"}, "a/b/cache", true, true},
+		{"trailing double star", []string{"docs/**"}, "docs/a/b.md", false, true},
+		{"middle double star", []string{"a/**/z.txt"}, "a/z.txt", false, true},
+		{"character class", []string{"file[0-9].txt"}, "file7.txt", false, true},
+		{"negated character class", []string{"file[!0-9].txt"}, "file7.txt", false, false},
+		{"escaped hash", []string{`\#notes`}, "#notes", false, true},
+		{"comment", []string{"# README.md"}, "README.md", false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := newIgnoreMatcher(tt.patterns)
+			if err != nil {
+				t.Fatalf("newIgnoreMatcher() error = %v", err)
+			}
+			if got := m.ignored(filepath.FromSlash(tt.path), tt.isDir); got != tt.want {
+				t.Errorf("ignored(%q) = %v, want %v", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckIgnorePatterns(t *testing.T) {
+	if err := checkIgnorePatterns([]string{"*.swp", "!.envrc", "build/"}); err != nil {
+		t.Errorf("checkIgnorePatterns() unexpected error = %v", err)
+	}
+	if err := checkIgnorePatterns([]string{"[a-"}); err == nil {
+		t.Error("checkIgnorePatterns() expected error for malformed pattern")
+	}
+}
+
+func TestScanLinksDirLinkignore(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	files := map[string]string{
+		".linkignore":           "README.md\n*.swp\n!.envrc\nscratch/\n",
+		".envrc":                "",
+		".tool-versions":        "",
+		"README.md":             "",
+		"config.yml":            "",
+		"config.yml.swp":        "",
+		"scratch/notes.txt":     "",
+		"sub/.linkignore":       "!README.md\nlocal.*\n",
+		"sub/README.md":         "",
+		"sub/local.env":         "",
+		"sub/shared.env":        "",
+		"other/local.env":       "",
+		".claude/settings.json": "",
+	}
+	for name, content := range files {
+		path := filepath.Join(tmpDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("failed to create dir: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to create file: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name      string
+		ignore    []string
+		wantFiles []string
+	}{
+		{
+			name:      "default",
+			ignore:    defaultIgnore,
+			wantFiles: []string{".envrc", "config.yml", "other/local.env", "sub/README.md", "sub/shared.env"},
+		},
+		{
+			name:      "no default",
+			ignore:    []string{},
+			wantFiles: []string{".envrc", ".tool-versions", "config.yml", "other/local.env", "sub/README.md", "sub/shared.env"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dirs, files, err := scanLinksDir(tmpDir, []string{".claude"}, tt.ignore)
+			if err != nil {
+				t.Fatalf("scanLinksDir() error = %v", err)
+			}
+			if got := relPaths(t, tmpDir, dirs); !slices.Equal(got, []string{".claude"}) {
+				t.Errorf("scanLinksDir() dirs = %v, want [.claude]", got)
+			}
+			if got := relPaths(t, tmpDir, files); !slices.Equal(got, tt.wantFiles) {
+				t.Errorf("scanLinksDir() files = %v, want %v", got, tt.wantFiles)
+			}
+		})
+	}
+}
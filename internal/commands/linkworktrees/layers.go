@@ -0,0 +1,204 @@
+package linkworktrees
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/template"
+
+	"github.com/oscarteg/toolbox/internal/git"
+)
+
+// defaultLinksDir is the links directory used when neither --links-dir nor the manifest configure any
+const defaultLinksDir = "links"
+
+// ErrNoLinksDir is returned when none of the links directories exist
+var ErrNoLinksDir = errors.New("links directory not found")
+
+// resolveLinksDirs returns the links directories to overlay, in order: dirs when given, otherwise the layers
+// of the manifest, otherwise the default links directory. Each is expanded with expandPath and relative ones
+// are taken relative to o.root, so every worktree and subdirectory uses the same ones. In verbose mode the
+// ones that do not exist are reported.
+func (o linkOptions) resolveLinksDirs(ctx context.Context, dirs []string) ([]string, error) {
+	if len(dirs) == 0 {
+		m, err := loadManifestIfAny(o.manifest, o.root)
+		if err != nil {
+			return nil, err
+		}
+		if m != nil {
+			dirs = m.Layers
+		}
+	}
+	if len(dirs) == 0 {
+		dirs = []string{defaultLinksDir}
+	}
+
+	resolved := make([]string, 0, len(dirs))
+	for _, dir := range dirs {
+		expanded, err := expandPath(dir, mainRepoName(ctx, o.repo))
+		if err != nil {
+			return nil, err
+		}
+		if o.root != "" && !filepath.IsAbs(expanded) {
+			expanded = filepath.Join(o.root, expanded)
+		}
+		if o.verbose && !isDir(expanded) {
+			fmt.Printf("Links directory %s does not exist, skipping it\n", expanded)
+		}
+		resolved = append(resolved, expanded)
+	}
+	return resolved, nil
+}
+
+// expandPath expands a leading ~, environment variables and the {{.Repo}} template field in p,
+// so a personal layer like ~/.config/toolbox/links/{{.Repo}} can be shared by every repository.
+// repo returns the directory name of the main worktree and is only called when p refers to it.
+func expandPath(p string, repo func() (string, error)) (string, error) {
+	if strings.Contains(p, "{{") {
+		tmpl, err := template.New("path").Option("missingkey=error").Parse(p)
+		if err != nil {
+			return "", fmt.Errorf("invalid path %q: %w", p, err)
+		}
+		name, err := repo()
+		if err != nil {
+			return "", err
+		}
+		var b strings.Builder
+		if err := tmpl.Execute(&b, struct{ Repo string }{name}); err != nil {
+			return "", fmt.Errorf("invalid path %q: %w", p, err)
+		}
+		p = b.String()
+	}
+
+	p = os.ExpandEnv(p)
+	if p == "~" || strings.HasPrefix(p, "~/") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("failed to expand %s: %w", p, err)
+		}
+		p = filepath.Join(home, strings.TrimPrefix(p, "~"))
+	}
+	return p, nil
+}
+
+// mainRepoName returns a function looking up the directory name of the main worktree of the repository at dir,
+// for expandPath
+func mainRepoName(ctx context.Context, dir string) func() (string, error) {
+	return func() (string, error) {
+		main, err := git.MainWorktree(ctx, dir)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSuffix(filepath.Base(main), ".git"), nil
+	}
+}
+
+// existingLinksDirs returns the links directories that exist, failing when there is none
+func (o linkOptions) existingLinksDirs() ([]string, error) {
+	var existing []string
+	for _, dir := range o.linksDirs {
+		if isDir(dir) {
+			existing = append(existing, dir)
+		}
+	}
+	if len(existing) == 0 {
+		return nil, fmt.Errorf("error: %w: '%s'", ErrNoLinksDir, strings.Join(o.linksDirs, "', '"))
+	}
+	return existing, nil
+}
+
+// isDir reports whether path is a directory, following symlinks
+func isDir(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+// overlayLinksDirs merges the entries of every links directory, given in layer order, per path: entries of a
+// later directory replace the entries of earlier ones at the same target and below it. Directories that are
+// linked as a whole but overlap with another layer are linked file by file instead, so both layers contribute.
+func overlayLinksDirs(layers [][]linkEntry) ([]linkEntry, error) {
+	var merged []linkEntry
+	for _, entries := range layers {
+		entries, err := expandOverlapping(entries, merged)
+		if err != nil {
+			return nil, err
+		}
+
+		next := make([]linkEntry, 0, len(merged)+len(entries))
+		for _, entry := range merged {
+			left, err := overridden(entry, entries)
+			if err != nil {
+				return nil, err
+			}
+			next = append(next, left...)
+		}
+		merged = append(next, entries...)
+	}
+	return merged, nil
+}
+
+// expandOverlapping replaces the directory entries that overlap with an earlier entry by their files
+func expandOverlapping(entries, earlier []linkEntry) ([]linkEntry, error) {
+	expanded := make([]linkEntry, 0, len(entries))
+	for _, entry := range entries {
+		overlaps := slices.ContainsFunc(earlier, func(e linkEntry) bool {
+			return isWithin(entry.target, e.target) || isWithin(e.target, entry.target)
+		})
+		if !entry.dir || !overlaps {
+			expanded = append(expanded, entry)
+			continue
+		}
+
+		files, err := expandDirEntry(entry)
+		if err != nil {
+			return nil, err
+		}
+		expanded = append(expanded, files...)
+	}
+	return expanded, nil
+}
+
+// overridden returns what is left of entry once the entries of a later layer are laid over it
+func overridden(entry linkEntry, later []linkEntry) ([]linkEntry, error) {
+	for _, e := range later {
+		switch {
+		case isWithin(e.target, entry.target):
+			// Replaced by e, or by the directory e links as a whole
+			return nil, nil
+		case !isWithin(entry.target, e.target):
+			continue
+		case !entry.dir:
+			// A file where the later layer has a directory
+			return nil, nil
+		}
+
+		files, err := expandDirEntry(entry)
+		if err != nil {
+			return nil, err
+		}
+		var left []linkEntry
+		for _, file := range files {
+			rest, err := overridden(file, later)
+			if err != nil {
+				return nil, err
+			}
+			left = append(left, rest...)
+		}
+		return left, nil
+	}
+	return []linkEntry{entry}, nil
+}
+
+// isWithinAny reports whether p is inside one of the roots
+func isWithinAny(roots []string, p string) bool {
+	for _, root := range roots {
+		if isWithin(root, p) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,146 @@
+package linkworktrees
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCollectEntriesOverlaysLinksDirs(t *testing.T) {
+	tmpDir := t.TempDir()
+	team := filepath.Join(tmpDir, "team")
+	personal := filepath.Join(tmpDir, "personal")
+	writeFiles(t, team, map[string]string{
+		".claude/settings.json":    "team",
+		".claude/commands/test.md": "team",
+		"a.txt":                    "team",
+		"b.txt":                    "team",
+		"conf/app.env":             "team",
+	})
+	writeFiles(t, personal, map[string]string{
+		".claude/settings.json": "personal",
+		"b.txt":                 "personal",
+		"conf":                  "personal",
+	})
+	// An empty manifest keeps one in the working directory out of the test
+	writeFiles(t, tmpDir, map[string]string{"empty.toml": ""})
+
+	opts := linkOptions{
+		linksDirs: []string{team, filepath.Join(tmpDir, "missing"), personal},
+		manifest:  filepath.Join(tmpDir, "empty.toml"),
+		mode:      modeSymlink,
+	}
+
+	entries, err := collectEntries(opts)
+	if err != nil {
+		t.Fatalf("collectEntries() error = %v", err)
+	}
+
+	got := make(map[string]string)
+	for _, e := range entries {
+		got[e.target] = e.layer
+		if !isWithin(e.layer, e.source) {
+			t.Errorf("source %s of %s is not inside its layer %s", e.source, e.target, e.layer)
+		}
+	}
+	want := map[string]string{
+		".claude/settings.json":    personal,
+		".claude/commands/test.md": team,
+		"a.txt":                    team,
+		"b.txt":                    personal,
+		"conf":                     personal,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("collectEntries() layers = %v, want %v", got, want)
+	}
+}
+
+func TestCollectEntriesOverlaysGenerated(t *testing.T) {
+	tmpDir := t.TempDir()
+	team := filepath.Join(tmpDir, "team")
+	personal := filepath.Join(tmpDir, "personal")
+	writeFiles(t, team, map[string]string{
+		"cfg.txt":               "team",
+		".env.age":              "team",
+		".claude/settings.json": "team",
+	})
+	writeFiles(t, personal, map[string]string{
+		"cfg.txt.tmpl":               "personal",
+		".env":                       "personal",
+		".claude/settings.json.tmpl": "personal",
+	})
+	if err := os.WriteFile(filepath.Join(personal, ".linkignore"), []byte("!.env\n"), 0644); err != nil {
+		t.Fatalf("failed to write .linkignore: %v", err)
+	}
+
+	opts := linkOptions{linksDirs: []string{team, personal}, root: tmpDir, mode: modeSymlink}
+	entries, err := collectEntries(opts)
+	if err != nil {
+		t.Fatalf("collectEntries() error = %v", err)
+	}
+
+	type result struct {
+		layer string
+		mode  linkMode
+	}
+	got := make(map[string]result)
+	for _, e := range entries {
+		got[filepath.ToSlash(e.target)] = result{e.layer, e.mode}
+	}
+	// The later layer wins for the path the entries end up at, whatever their extension
+	want := map[string]result{
+		"cfg.txt":               {personal, modeTemplate},
+		".env":                  {personal, modeSymlink},
+		".claude/settings.json": {personal, modeTemplate},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("collectEntries() = %v, want %v", got, want)
+	}
+}
+
+func TestCollectEntriesWithoutLinksDirs(t *testing.T) {
+	tmpDir := t.TempDir()
+	opts := linkOptions{linksDirs: []string{filepath.Join(tmpDir, "links"), filepath.Join(tmpDir, "personal")}}
+
+	if _, err := collectEntries(opts); !errors.Is(err, ErrNoLinksDir) {
+		t.Errorf("collectEntries() error = %v, want %v", err, ErrNoLinksDir)
+	}
+}
+
+func TestExpandPath(t *testing.T) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		t.Skipf("no home directory: %v", err)
+	}
+	t.Setenv("TOOLBOX_TEST_DIR", "/srv/links")
+	repo := func() (string, error) { return "toolbox", nil }
+
+	tests := []struct {
+		name    string
+		dir     string
+		want    string
+		wantErr bool
+	}{
+		{name: "plain", dir: "links", want: "links"},
+		{name: "home", dir: "~/.config/toolbox/links", want: filepath.Join(home, ".config/toolbox/links")},
+		{name: "home and repo", dir: "~/.config/toolbox/links/{{.Repo}}", want: filepath.Join(home, ".config/toolbox/links/toolbox")},
+		{name: "environment", dir: "$TOOLBOX_TEST_DIR/{{.Repo}}", want: "/srv/links/toolbox"},
+		{name: "tilde inside a name", dir: "links~/x", want: "links~/x"},
+		{name: "unknown field", dir: "links/{{.Branch}}", wantErr: true},
+		{name: "malformed template", dir: "links/{{.Repo", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := expandPath(tt.dir, repo)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expandPath() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("expandPath() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package linkworktrees
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/oscarteg/toolbox/internal/git"
+)
+
+// Options configures Link, Unlink and ManagedPaths for other commands
+type Options struct {
+	LinksDirs []string // directories containing the files to link, defaults to the layers of the manifest or links
+	Manifest  string   // manifest to use, defaults to the one in the main worktree if any
+	DryRun    bool
+	Verbose   bool
+}
+
+// linkOptions returns the options of a linkworktrees run with the defaults of its flags
+func (o Options) linkOptions(ctx context.Context) (linkOptions, error) {
+	opts := linkOptions{
+		manifest:   o.Manifest,
+		dirs:       defaultDirs,
+		mode:       modeSymlink,
+		onConflict: policySkip,
+		jobs:       runtime.NumCPU(),
+		dryRun:     o.DryRun,
+		verbose:    o.Verbose,
+	}
+	var err error
+	if opts.root, err = git.MainWorktree(ctx, ""); err != nil {
+		return opts, err
+	}
+	if opts.linksDirs, err = opts.resolveLinksDirs(ctx, o.LinksDirs); err != nil {
+		return opts, err
+	}
+	if opts.identities, err = opts.resolveIdentities(ctx, nil); err != nil {
+		return opts, err
+	}
+
+	commonDir, err := git.CommonDir(ctx, "")
+	if err != nil {
+		return opts, err
+	}
+	opts.recordsPath = filepath.Join(commonDir, recordsFileName)
+	opts.journalPath = filepath.Join(commonDir, journalFileName)
+
+	if opts.excludePath, err = git.GitPath(ctx, "", "info/exclude"); err != nil {
+		return opts, err
+	}
+	return opts, nil
+}
+
+// Link links the links directories into the given worktrees with the manifest settings, skipping conflicts.
+// It fails with ErrNoLinksDir when none of the links directories exist.
+func Link(ctx context.Context, o Options, worktrees ...string) error {
+	opts, err := o.linkOptions(ctx)
+	if err != nil {
+		return err
+	}
+
+	entries, err := collectEntries(opts)
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		fmt.Printf("No files found in '%s'\n", strings.Join(opts.linksDirs, "', '"))
+		return nil
+	}
+
+	return applyLinks(ctx, opts, planLinks(entries, worktrees))
+}
+
+// Unlink removes the links and unmodified copies made by linkworktrees from the given worktrees
+func Unlink(ctx context.Context, o Options, worktrees ...string) error {
+	opts, err := o.linkOptions(ctx)
+	if err != nil {
+		return err
+	}
+	tx, err := lockRun(opts)
+	if err != nil {
+		return err
+	}
+	defer tx.close()
+
+	l, err := newLinker(opts)
+	if err != nil {
+		return err
+	}
+
+	for _, worktree := range worktrees {
+		if _, err := unlinkWorktree(ctx, opts, l, worktree); err != nil {
+			return err
+		}
+	}
+
+	if opts.dryRun {
+		return nil
+	}
+	if err := l.records.save(); err != nil {
+		return fmt.Errorf("failed to save link records: %w", err)
+	}
+	return pruneExclude(ctx, opts, l)
+}
+
+// ManagedPaths returns the absolute paths of the links and unmodified copies made by linkworktrees inside worktree
+func ManagedPaths(ctx context.Context, o Options, worktree string) ([]string, error) {
+	opts, err := o.linkOptions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	l, err := newLinker(opts)
+	if err != nil {
+		return nil, err
+	}
+	return managedPaths(ctx, l, worktree)
+}
@@ -0,0 +1,337 @@
+package linkworktrees
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/oscarteg/toolbox/internal/git"
+	"gopkg.in/yaml.v2"
+)
+
+// manifestNames lists the manifest file names looked up at the repository root, in order of preference
+var manifestNames = []string{"toolbox.links.toml", "toolbox.links.yaml", "toolbox.links.yml"}
+
+// Manifest describes exactly which entries of the links directory are shared with worktrees
+type Manifest struct {
+	// Layers are the links directories overlaid in order when --links-dir is not given, later ones
+	// overriding earlier ones per path. ~, environment variables and {{.Repo}} are expanded.
+	Layers []string `toml:"layers" yaml:"layers"`
+	// Targets are the directories 'toolbox link' links into when --to is not given, expanded like Layers
+	Targets []string `toml:"targets" yaml:"targets"`
+	// Identities are the age identity files .age sources are decrypted with when --identity is not given, expanded like Layers
+	Identities []string `toml:"identities" yaml:"identities"`
+	// Mode is the default link mode, e.g. relative-symlink for relocatable worktrees
+	Mode string `toml:"mode" yaml:"mode"`
+	// Dirs are glob patterns of directories linked as a whole when walking the links directory
+	Dirs []string `toml:"dirs" yaml:"dirs"`
+	// Ignore are gitignore patterns replacing the default of skipping hidden files when walking the links directory.
+	// Patterns from .linkignore files are applied after them.
+	Ignore []string        `toml:"ignore" yaml:"ignore"`
+	Links  []ManifestEntry `toml:"links" yaml:"links"`
+	// Worktree configures how 'toolbox worktree new' creates worktrees
+	Worktree WorktreeConfig `toml:"worktree" yaml:"worktree"`
+}
+
+// WorktreeConfig is the [worktree] section of the manifest
+type WorktreeConfig struct {
+	// Dir is a template for the path of new worktrees, relative to the main worktree
+	Dir string `toml:"dir" yaml:"dir"`
+	// Branch is a template for the branch checked out in new worktrees
+	Branch string `toml:"branch" yaml:"branch"`
+	// Base is the commit new branches start from, defaults to HEAD
+	Base string `toml:"base" yaml:"base"`
+	// Bootstrap commands run in every new worktree through sh -c, e.g. go mod download
+	Bootstrap []string `toml:"bootstrap" yaml:"bootstrap"`
+}
+
+// ManifestEntry is a single source to link into every worktree
+type ManifestEntry struct {
+	// Source is the file or directory to link, relative to the links directory
+	Source string `toml:"source" yaml:"source"`
+	// Target is the path inside the worktree, defaults to Source
+	Target string `toml:"target" yaml:"target"`
+	// Dir links a directory as a whole instead of linking each file inside it
+	Dir bool `toml:"dir" yaml:"dir"`
+	// Optional entries are skipped when their source does not exist
+	Optional bool `toml:"optional" yaml:"optional"`
+	// Mode overrides --mode for this entry
+	Mode string `toml:"mode" yaml:"mode"`
+	// Perm sets the octal permissions of copies, rendered templates and decrypted files, e.g. "0600" for
+	// credentials or "0755" for scripts. They follow the source by default, 0600 for encrypted sources.
+	Perm string `toml:"perm" yaml:"perm"`
+	// DirPerm sets the octal permissions of the parent directories created for the targets, defaults to 0755
+	DirPerm string `toml:"dir_perm" yaml:"dir_perm"`
+}
+
+// linkEntry is a resolved source that gets linked into every worktree
+type linkEntry struct {
+	source string // absolute path of the source file or directory
+	target string // path relative to the worktree root
+	dir    bool   // whether source is linked as a whole directory
+	mode   linkMode
+	layer  string // links directory the source comes from
+
+	perm    os.FileMode // permissions of the written file, 0 to follow the source, see wantPerm
+	dirPerm os.FileMode // permissions of created parent directories, 0 for defaultDirPerm
+}
+
+// LoadWorktreeConfig returns the [worktree] section of the manifest at path, or of the manifest
+// in the main worktree when path is empty. It is empty when there is no manifest.
+func LoadWorktreeConfig(ctx context.Context, path string) (WorktreeConfig, error) {
+	if path == "" {
+		main, err := git.MainWorktree(ctx, "")
+		if err != nil {
+			return WorktreeConfig{}, err
+		}
+		found, err := findManifest(main)
+		if err != nil || found == "" {
+			return WorktreeConfig{}, err
+		}
+		path = found
+	}
+
+	m, err := loadManifest(path)
+	if err != nil {
+		return WorktreeConfig{}, err
+	}
+	return m.Worktree, nil
+}
+
+// resolveManifest returns path, or the manifest found in dir (the current directory when empty) when path is empty
+func resolveManifest(path, dir string) (string, error) {
+	if path != "" {
+		return path, nil
+	}
+	if dir == "" {
+		dir = "."
+	}
+	found, err := findManifest(dir)
+	if err != nil {
+		return "", fmt.Errorf("failed to look up manifest: %w", err)
+	}
+	return found, nil
+}
+
+// loadManifestIfAny loads the manifest at path, or the one in dir when path is empty, see resolveManifest.
+// It returns nil when there is no manifest.
+func loadManifestIfAny(path, dir string) (*Manifest, error) {
+	path, err := resolveManifest(path, dir)
+	if err != nil || path == "" {
+		return nil, err
+	}
+	return loadManifest(path)
+}
+
+// findManifest returns the path of the first manifest found in dir, or an empty string if there is none
+func findManifest(dir string) (string, error) {
+	for _, name := range manifestNames {
+		path := filepath.Join(dir, name)
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		} else if !os.IsNotExist(err) {
+			return "", err
+		}
+	}
+	return "", nil
+}
+
+// loadManifest reads a TOML or YAML manifest, rejecting unknown keys
+func loadManifest(path string) (*Manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var m Manifest
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".toml":
+		md, err := toml.Decode(string(data), &m)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+		if undecoded := md.Undecoded(); len(undecoded) > 0 {
+			return nil, fmt.Errorf("unknown key %q in %s", undecoded[0].String(), path)
+		}
+	case ".yaml", ".yml":
+		if err := yaml.UnmarshalStrict(data, &m); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported manifest format %q", ext)
+	}
+
+	return &m, nil
+}
+
+// validate checks every entry against the links directories and reports all problems at once.
+// A source has to exist in at least one of them.
+func (m *Manifest) validate(linksDirs []string) error {
+	var errs []error
+	if m.Mode != "" {
+		if _, err := parseLinkMode(m.Mode); err != nil {
+			errs = append(errs, fmt.Errorf("mode: %w", err))
+		}
+	}
+	if err := checkDirPatterns(m.Dirs); err != nil {
+		errs = append(errs, fmt.Errorf("dirs: %w", err))
+	}
+	if err := checkIgnorePatterns(m.Ignore); err != nil {
+		errs = append(errs, fmt.Errorf("ignore: %w", err))
+	}
+
+	targets := make(map[string]int, len(m.Links))
+
+	for i := range m.Links {
+		entry := &m.Links[i]
+		if entry.Target == "" {
+			entry.Target = entry.Source
+		}
+
+		if entry.Mode != "" {
+			if _, err := parseLinkMode(entry.Mode); err != nil {
+				errs = append(errs, fmt.Errorf("links[%d]: %w", i, err))
+			}
+		}
+		if entry.Perm != "" {
+			if _, err := parsePerm(entry.Perm); err != nil {
+				errs = append(errs, fmt.Errorf("links[%d]: perm: %w", i, err))
+			}
+		}
+		if entry.DirPerm != "" {
+			if _, err := parsePerm(entry.DirPerm); err != nil {
+				errs = append(errs, fmt.Errorf("links[%d]: dir_perm: %w", i, err))
+			}
+		}
+
+		if err := checkRelPath(entry.Source); err != nil {
+			errs = append(errs, fmt.Errorf("links[%d]: source: %w", i, err))
+			continue
+		}
+		if err := checkRelPath(entry.Target); err != nil {
+			errs = append(errs, fmt.Errorf("links[%d]: target: %w", i, err))
+			continue
+		}
+
+		target := filepath.Clean(entry.Target)
+		if prev, ok := targets[target]; ok {
+			errs = append(errs, fmt.Errorf("links[%d]: target %q already used by links[%d]", i, entry.Target, prev))
+			continue
+		}
+		targets[target] = i
+
+		found := false
+		for _, linksDir := range linksDirs {
+			info, err := os.Stat(filepath.Join(linksDir, entry.Source))
+			switch {
+			case os.IsNotExist(err):
+				continue
+			case err != nil:
+				errs = append(errs, fmt.Errorf("links[%d]: %w", i, err))
+			case entry.Dir && !info.IsDir():
+				errs = append(errs, fmt.Errorf("links[%d]: source %q in %s is not a directory", i, entry.Source, linksDir))
+			}
+			found = true
+		}
+		if !found && !entry.Optional {
+			errs = append(errs, fmt.Errorf("links[%d]: source %q not found in %s", i, entry.Source, strings.Join(linksDirs, ", ")))
+		}
+	}
+
+	// A target nested inside a directory link would end up inside the links directory itself
+	for i, entry := range m.Links {
+		if !entry.Dir {
+			continue
+		}
+		prefix := filepath.Clean(entry.Target) + string(filepath.Separator)
+		for j, other := range m.Links {
+			if j != i && strings.HasPrefix(filepath.Clean(other.Target), prefix) {
+				errs = append(errs, fmt.Errorf("links[%d]: target %q is nested inside directory link links[%d]", j, other.Target, i))
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// entries resolves the manifest into the link entries of one links directory, expanding non-dir directory
+// sources into their files. Sources the directory does not have are skipped, validate checked the others have them.
+func (m *Manifest) entries(linksDir string) ([]linkEntry, error) {
+	entries := make([]linkEntry, 0, len(m.Links))
+
+	for _, e := range m.Links {
+		sourcePath := filepath.Join(linksDir, e.Source)
+		info, err := os.Stat(sourcePath)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		absSource, err := filepath.Abs(sourcePath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get absolute path for %s: %w", sourcePath, err)
+		}
+
+		entry := linkEntry{source: absSource, target: filepath.Clean(e.Target), dir: e.Dir, mode: linkMode(e.Mode)}
+		if entry.perm, err = entryPerm(e.Source, e.Perm); err != nil {
+			return nil, err
+		}
+		if entry.dirPerm, err = entryPerm(e.Source, e.DirPerm); err != nil {
+			return nil, err
+		}
+		if e.Dir || !info.IsDir() {
+			entries = append(entries, entry)
+			continue
+		}
+
+		files, err := findLinkFiles(absSource, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to find files in %s: %w", sourcePath, err)
+		}
+		for _, file := range files {
+			rel, err := filepath.Rel(absSource, file)
+			if err != nil {
+				return nil, err
+			}
+			fileEntry := entry
+			fileEntry.source = file
+			fileEntry.target = filepath.Join(e.Target, rel)
+			entries = append(entries, fileEntry)
+		}
+	}
+
+	return entries, nil
+}
+
+// entryPerm parses the permissions s of the entry for source, which are unset when empty
+func entryPerm(source, s string) (os.FileMode, error) {
+	if s == "" {
+		return 0, nil
+	}
+	perm, err := parsePerm(s)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", source, err)
+	}
+	return perm, nil
+}
+
+// checkRelPath ensures p is a non-empty relative path that stays inside its root
+func checkRelPath(p string) error {
+	if p == "" {
+		return errors.New("path is empty")
+	}
+	if filepath.IsAbs(p) {
+		return fmt.Errorf("%q must be relative", p)
+	}
+	clean := filepath.Clean(p)
+	if clean == "." || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("%q must stay inside its root", p)
+	}
+	return nil
+}
@@ -0,0 +1,284 @@
+package linkworktrees
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadManifest(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		want    []ManifestEntry
+		wantErr bool
+	}{
+		{
+			name: "toml",
+			file: "toolbox.links.toml",
+			content: `
+[[links]]
+source = ".claude"
+dir = true
+
+[[links]]
+source = "env/local.env"
+target = ".env"
+optional = true
+`,
+			want: []ManifestEntry{
+				{Source: ".claude", Dir: true},
+				{Source: "env/local.env", Target: ".env", Optional: true},
+			},
+		},
+		{
+			name: "yaml",
+			file: "toolbox.links.yaml",
+			content: `
+links:
+  - source: .claude
+    dir: true
+  - source: env/local.env
+    target: .env
+`,
+			want: []ManifestEntry{
+				{Source: ".claude", Dir: true},
+				{Source: "env/local.env", Target: ".env"},
+			},
+		},
+		{
+			name:    "unknown toml key",
+			file:    "unknown.toml",
+			content: "[[links]]\nsource = \"a\"\ndirectory = true\n",
+			wantErr: true,
+		},
+		{
+			name:    "unknown yaml key",
+			file:    "unknown.yml",
+			content: "links:\n  - source: a\n    directory: true\n",
+			wantErr: true,
+		},
+		{
+			name:    "unsupported format",
+			file:    "toolbox.links.json",
+			content: "{}",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(tmpDir, tt.file)
+			if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+				t.Fatalf("failed to write manifest: %v", err)
+			}
+
+			got, err := loadManifest(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("loadManifest() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			if len(got.Links) != len(tt.want) {
+				t.Fatalf("loadManifest() returned %d entries, want %d", len(got.Links), len(tt.want))
+			}
+			for i, want := range tt.want {
+				if got.Links[i] != want {
+					t.Errorf("loadManifest() entry %d = %+v, want %+v", i, got.Links[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestManifestValidate(t *testing.T) {
+	linksDir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(linksDir, ".claude"), 0755); err != nil {
+		t.Fatalf("failed to create dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(linksDir, "file.txt"), []byte("test"), 0644); err != nil {
+		t.Fatalf("failed to create file: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		links   []ManifestEntry
+		ignore  []string
+		wantErr string
+	}{
+		{
+			name:  "valid",
+			links: []ManifestEntry{{Source: ".claude", Dir: true}, {Source: "file.txt", Target: "conf/file.txt"}},
+		},
+		{
+			name:  "missing optional source",
+			links: []ManifestEntry{{Source: "missing", Optional: true}},
+		},
+		{
+			name:    "missing source",
+			links:   []ManifestEntry{{Source: "missing"}},
+			wantErr: "not found",
+		},
+		{
+			name:    "empty source",
+			links:   []ManifestEntry{{}},
+			wantErr: "path is empty",
+		},
+		{
+			name:    "absolute target",
+			links:   []ManifestEntry{{Source: "file.txt", Target: "/etc/file.txt"}},
+			wantErr: "must be relative",
+		},
+		{
+			name:    "escaping source",
+			links:   []ManifestEntry{{Source: "../file.txt"}},
+			wantErr: "must stay inside",
+		},
+		{
+			name:    "dir source is a file",
+			links:   []ManifestEntry{{Source: "file.txt", Dir: true}},
+			wantErr: "is not a directory",
+		},
+		{
+			name:    "duplicate target",
+			links:   []ManifestEntry{{Source: "file.txt"}, {Source: ".claude", Target: "file.txt"}},
+			wantErr: "already used",
+		},
+		{
+			name:    "target nested in directory link",
+			links:   []ManifestEntry{{Source: ".claude", Dir: true}, {Source: "file.txt", Target: ".claude/file.txt"}},
+			wantErr: "nested inside",
+		},
+		{
+			name:    "invalid perm",
+			links:   []ManifestEntry{{Source: "file.txt", Perm: "0800"}},
+			wantErr: "perm:",
+		},
+		{
+			name:    "invalid dir_perm",
+			links:   []ManifestEntry{{Source: "file.txt", DirPerm: "rwx"}},
+			wantErr: "dir_perm:",
+		},
+		{
+			name:    "invalid ignore pattern",
+			ignore:  []string{"*.swp", "[a-"},
+			wantErr: "ignore:",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &Manifest{Links: tt.links, Ignore: tt.ignore}
+			err := m.validate([]string{linksDir})
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("validate() unexpected error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("validate() error = %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestManifestEntries(t *testing.T) {
+	linksDir := t.TempDir()
+	for _, file := range []string{".claude/settings.json", "vscode/settings.json", "vscode/launch.json", "file.txt"} {
+		path := filepath.Join(linksDir, file)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("failed to create dir: %v", err)
+		}
+		if err := os.WriteFile(path, []byte("test"), 0644); err != nil {
+			t.Fatalf("failed to create file %s: %v", file, err)
+		}
+	}
+
+	m := &Manifest{Links: []ManifestEntry{
+		{Source: ".claude", Dir: true},
+		{Source: "vscode", Target: ".vscode", Perm: "0600", DirPerm: "0700"},
+		{Source: "file.txt"},
+		{Source: "missing", Optional: true},
+	}}
+	if err := m.validate([]string{linksDir}); err != nil {
+		t.Fatalf("validate() error = %v", err)
+	}
+
+	got, err := m.entries(linksDir)
+	if err != nil {
+		t.Fatalf("entries() error = %v", err)
+	}
+
+	want := map[string]linkEntry{
+		".claude":               {source: filepath.Join(linksDir, ".claude"), target: ".claude", dir: true},
+		".vscode/settings.json": {source: filepath.Join(linksDir, "vscode", "settings.json"), target: ".vscode/settings.json", perm: 0600, dirPerm: 0700},
+		".vscode/launch.json":   {source: filepath.Join(linksDir, "vscode", "launch.json"), target: ".vscode/launch.json", perm: 0600, dirPerm: 0700},
+		"file.txt":              {source: filepath.Join(linksDir, "file.txt"), target: "file.txt"},
+	}
+
+	if len(got) != len(want) {
+		t.Fatalf("entries() returned %d entries, want %d: %+v", len(got), len(want), got)
+	}
+	for _, entry := range got {
+		if want[entry.target] != entry {
+			t.Errorf("entries() got %+v, want %+v", entry, want[entry.target])
+		}
+	}
+}
+
+func TestManifestValidateLayers(t *testing.T) {
+	team, personal := t.TempDir(), t.TempDir()
+	writeFiles(t, team, map[string]string{"shared.env": "team"})
+	writeFiles(t, personal, map[string]string{"local.env": "personal", "conf": "personal"})
+	if err := os.MkdirAll(filepath.Join(team, "conf"), 0755); err != nil {
+		t.Fatalf("failed to create dir: %v", err)
+	}
+
+	m := &Manifest{Links: []ManifestEntry{{Source: "shared.env"}, {Source: "local.env"}}}
+	if err := m.validate([]string{team, personal}); err != nil {
+		t.Errorf("validate() error = %v, want sources found in any layer", err)
+	}
+
+	m = &Manifest{Links: []ManifestEntry{{Source: "conf", Dir: true}}}
+	if err := m.validate([]string{team, personal}); err == nil || !strings.Contains(err.Error(), "is not a directory") {
+		t.Errorf("validate() error = %v, want a layer where the directory is a file rejected", err)
+	}
+
+	m = &Manifest{Links: []ManifestEntry{{Source: "missing.env"}}}
+	if err := m.validate([]string{team, personal}); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("validate() error = %v, want a source missing from every layer rejected", err)
+	}
+}
+
+func TestLoadWorktreeConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "toolbox.links.toml")
+	content := `
+dirs = [".claude"]
+
+[worktree]
+dir = "../{{.Repo}}-{{.Slug}}"
+base = "origin/main"
+bootstrap = ["go mod download"]
+`
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write manifest: %v", err)
+	}
+
+	got, err := LoadWorktreeConfig(context.Background(), path)
+	if err != nil {
+		t.Fatalf("LoadWorktreeConfig() error = %v", err)
+	}
+	want := WorktreeConfig{Dir: "../{{.Repo}}-{{.Slug}}", Base: "origin/main", Bootstrap: []string{"go mod download"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("LoadWorktreeConfig() = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,487 @@
+package linkworktrees
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// linkMode decides how an entry is materialized inside a worktree
+type linkMode string
+
+const (
+	modeSymlink         linkMode = "symlink"
+	modeRelativeSymlink linkMode = "relative-symlink"
+	modeHardlink        linkMode = "hardlink"
+	modeCopy            linkMode = "copy"
+	// modeTemplate renders a .tmpl source per worktree, it cannot be chosen with --mode
+	modeTemplate linkMode = "template"
+	// modeDecrypt decrypts a .age source into a private file per worktree, it cannot be chosen with --mode
+	modeDecrypt linkMode = "decrypt"
+)
+
+// parseLinkMode validates a --mode value
+func parseLinkMode(s string) (linkMode, error) {
+	switch m := linkMode(s); m {
+	case modeSymlink, modeRelativeSymlink, modeHardlink, modeCopy:
+		return m, nil
+	default:
+		return "", fmt.Errorf("unsupported mode %q, expected symlink, relative-symlink, hardlink or copy", s)
+	}
+}
+
+// isSymlink reports whether the mode creates symlinks, which can point at whole directories
+func (m linkMode) isSymlink() bool {
+	return m == modeSymlink || m == modeRelativeSymlink
+}
+
+// verb describes the mode in progress output
+func (m linkMode) verb() string {
+	switch m {
+	case modeHardlink:
+		return "hardlink"
+	case modeCopy:
+		return "copy"
+	case modeTemplate:
+		return "render"
+	case modeDecrypt:
+		return "decrypt"
+	default:
+		return "link"
+	}
+}
+
+// linker inspects and materializes planned links, tracking the hardlinks and copies it makes
+type linker struct {
+	repo      string   // directory inside the repository, empty for the current directory
+	linksDirs []string // absolute paths of the links directories
+	targets   []string // target directories of the link command, nil when linking worktrees
+	planned   []string // entry targets unlink looks for in target directories, see findTargetLinks
+	records   *linkRecords
+	now       time.Time
+	tx        *transaction // journals the changes, nil to make them directly
+	mu        sync.Mutex   // guards records while ops of different worktrees run concurrently
+
+	templateData map[string]templateData // keyed by worktree path, see loadTemplateData
+	decryptor    decryptor
+	decrypted    map[string][]byte // plaintext of the encrypted sources decrypted so far, guarded by mu
+}
+
+// check inspects the target of op according to the mode of its entry
+func (l *linker) check(op linkOp) (linkStatus, error) {
+	parent, err := symlinkedParent(op)
+	if err != nil {
+		return linkStatus{}, err
+	}
+	if parent != "" {
+		// The target is reached through a symlink, typically a directory linked as a whole
+		// by an earlier run. Looking further would inspect, and possibly replace, the
+		// files on the other side of that symlink instead.
+		dest, err := readLinkDest(parent)
+		if err != nil {
+			return linkStatus{}, err
+		}
+		return linkStatus{
+			Worktree: op.worktree,
+			Target:   op.entry.target,
+			Source:   op.entry.source,
+			State:    stateStale,
+			Actual:   dest,
+			parent:   parent,
+		}, nil
+	}
+
+	if !op.entry.mode.isSymlink() {
+		return l.checkMaterialized(op)
+	}
+
+	status, err := checkLink(op)
+	if err != nil || status.State != stateShadowed {
+		return status, err
+	}
+
+	// A hardlink or copy left behind by a previous run in another mode is outdated, not in the way
+	var recorded bool
+	if op.entry.dir {
+		recorded, err = isRecordedDir(op.target, l.records)
+	} else {
+		recorded, err = isRecorded(op.target, l.records)
+	}
+	if err != nil {
+		return status, err
+	}
+	if recorded {
+		status.State = stateStale
+		status.Actual = op.entry.source
+	}
+	return status, nil
+}
+
+// checkForm is check, but reports links in the other symlink form as stale.
+// status accepts both link forms, while linking converts them to the requested one.
+func (l *linker) checkForm(op linkOp) (linkStatus, error) {
+	status, err := l.check(op)
+	if err != nil || status.State != stateLinked || !op.entry.mode.isSymlink() {
+		return status, err
+	}
+
+	ok, err := hasLinkForm(op)
+	if err != nil {
+		return status, err
+	}
+	if !ok {
+		status.State = stateStale
+		status.Actual = op.entry.source
+	}
+	return status, nil
+}
+
+// checkMaterialized inspects a hardlink or copy target. A file counts as linked when it holds the
+// source content, and as stale when it still holds the content recorded when it was last written.
+func (l *linker) checkMaterialized(op linkOp) (linkStatus, error) {
+	info, err := os.Lstat(op.target)
+	if err != nil && !os.IsNotExist(err) {
+		return linkStatus{}, err
+	}
+
+	if err == nil && info.Mode()&os.ModeSymlink != 0 {
+		// A symlink left behind by a previous run in a symlink mode is outdated, not linked
+		status, err := checkLink(op)
+		if err == nil && status.State == stateLinked {
+			status.State = stateStale
+			status.Actual = op.entry.source
+		}
+		return status, err
+	}
+
+	status := linkStatus{
+		Worktree: op.worktree,
+		Target:   op.entry.target,
+		Source:   op.entry.source,
+	}
+
+	switch {
+	case os.IsNotExist(err):
+		status.State = stateMissing
+		return status, nil
+	case !info.Mode().IsRegular():
+		status.State = stateShadowed
+		return status, nil
+	}
+
+	current, err := l.isCurrent(op, info)
+	if err != nil {
+		return status, err
+	}
+	if want, ok := op.entry.wantPerm(); current && ok && info.Mode().Perm() != want {
+		// Right content with the wrong permissions, rewritten like an outdated copy
+		status.State = stateStale
+		status.Actual = op.entry.source
+		status.Perm = formatPerm(info.Mode().Perm())
+		status.WantPerm = formatPerm(want)
+		return status, nil
+	}
+	if current {
+		status.State = stateLinked
+		return status, nil
+	}
+
+	if record, ok := l.records.Entries[op.target]; ok {
+		held, err := l.records.holds(op.target, record)
+		if err != nil {
+			return status, err
+		}
+		if held {
+			status.State = stateStale
+			status.Actual = record.Source
+			return status, nil
+		}
+	}
+
+	status.State = stateShadowed
+	return status, nil
+}
+
+// isCurrent reports whether the regular file at the op target already matches its source, for templates the
+// source rendered for the worktree and for encrypted sources the plaintext
+func (l *linker) isCurrent(op linkOp, info os.FileInfo) (bool, error) {
+	if op.entry.mode == modeTemplate || op.entry.mode == modeDecrypt {
+		content, err := l.generate(op)
+		if err != nil {
+			return false, err
+		}
+		targetHash, err := hashFile(op.target)
+		if err != nil {
+			return false, err
+		}
+		return hashBytes(content) == targetHash, nil
+	}
+
+	sourceInfo, err := os.Stat(op.entry.source)
+	if err != nil {
+		return false, err
+	}
+
+	if op.entry.mode == modeHardlink {
+		return os.SameFile(sourceInfo, info), nil
+	}
+
+	if sourceInfo.Size() != info.Size() {
+		return false, nil
+	}
+	sourceHash, err := hashFile(op.entry.source)
+	if err != nil {
+		return false, err
+	}
+	targetHash, err := hashFile(op.target)
+	if err != nil {
+		return false, err
+	}
+	return sourceHash == targetHash, nil
+}
+
+// generate returns the content written for a template or encrypted source
+func (l *linker) generate(op linkOp) ([]byte, error) {
+	if op.entry.mode == modeDecrypt {
+		return l.plaintext(op)
+	}
+	return l.render(op)
+}
+
+// materialize creates the target of op according to its mode, along with any missing parent directories
+func (l *linker) materialize(op linkOp) error {
+	targetDir := filepath.Dir(op.target)
+	created, err := l.tx.mkdirAll(targetDir, op.entry.dirPerm)
+	l.mu.Lock()
+	l.records.addDirs(created)
+	l.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to create directory %s: %w", targetDir, err)
+	}
+
+	if err := l.tx.create(op.target, func() error { return l.write(op) }); err != nil {
+		return err
+	}
+	if op.entry.mode.isSymlink() {
+		l.mu.Lock()
+		delete(l.records.Entries, op.target)
+		l.mu.Unlock()
+		return nil
+	}
+
+	hash, err := l.records.digestFile(op.entry.mode, op.target)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	l.records.Entries[op.target] = linkRecord{Source: op.entry.source, Mode: op.entry.mode, Hash: hash}
+	l.mu.Unlock()
+	return nil
+}
+
+// write creates the target of op according to its mode
+func (l *linker) write(op linkOp) error {
+	switch op.entry.mode {
+	case modeHardlink:
+		if err := os.Link(op.entry.source, op.target); err != nil {
+			return fmt.Errorf("failed to create hardlink %s -> %s: %w", op.entry.source, op.target, err)
+		}
+	case modeCopy:
+		if err := copyFile(op.entry.source, op.target, op.entry.perm); err != nil {
+			return fmt.Errorf("failed to copy %s -> %s: %w", op.entry.source, op.target, err)
+		}
+	case modeTemplate:
+		if err := l.renderFile(op); err != nil {
+			return fmt.Errorf("failed to render %s -> %s: %w", op.entry.source, op.target, err)
+		}
+	case modeDecrypt:
+		if err := l.decryptFile(op); err != nil {
+			return fmt.Errorf("failed to decrypt %s -> %s: %w", op.entry.source, op.target, err)
+		}
+	default:
+		return createSymlink(op)
+	}
+	return nil
+}
+
+// symlinkedParent returns the first directory between the worktree root and the op target that is a symlink
+func symlinkedParent(op linkOp) (string, error) {
+	rel, err := filepath.Rel(op.worktree, filepath.Dir(op.target))
+	if err != nil || rel == "." {
+		return "", err
+	}
+
+	current := op.worktree
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		current = filepath.Join(current, part)
+		info, err := os.Lstat(current)
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		if err != nil {
+			return "", err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return current, nil
+		}
+	}
+	return "", nil
+}
+
+// hasLinkForm reports whether the symlink at the op target is absolute or relative as its mode asks for
+func hasLinkForm(op linkOp) (bool, error) {
+	dest, err := os.Readlink(op.target)
+	if err != nil {
+		return false, err
+	}
+	return filepath.IsAbs(dest) == (op.entry.mode == modeSymlink), nil
+}
+
+// createSymlink creates the symlink for op, relative to the target directory in relative-symlink mode
+func createSymlink(op linkOp) error {
+	dest := op.entry.source
+	if op.entry.mode == modeRelativeSymlink {
+		rel, err := filepath.Rel(filepath.Dir(op.target), op.entry.source)
+		if err != nil {
+			return fmt.Errorf("failed to get relative path for %s: %w", op.entry.source, err)
+		}
+		dest = rel
+	}
+
+	if err := os.Symlink(dest, op.target); err != nil {
+		return fmt.Errorf("failed to create symlink %s -> %s: %w", dest, op.target, err)
+	}
+	return nil
+}
+
+// expandDirEntries replaces directory entries by entries for each regular file inside them,
+// for modes that cannot materialize a directory as a whole
+func expandDirEntries(entries []linkEntry) ([]linkEntry, error) {
+	expanded := make([]linkEntry, 0, len(entries))
+
+	for _, entry := range entries {
+		if !entry.dir || entry.mode.isSymlink() {
+			expanded = append(expanded, entry)
+			continue
+		}
+
+		files, err := expandDirEntry(entry)
+		if err != nil {
+			return nil, err
+		}
+		expanded = append(expanded, files...)
+	}
+
+	return expanded, nil
+}
+
+// expandDirEntry returns an entry for every regular file inside the directory entry
+func expandDirEntry(entry linkEntry) ([]linkEntry, error) {
+	var files []linkEntry
+	err := filepath.WalkDir(entry.source, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(entry.source, path)
+		if err != nil {
+			return err
+		}
+		file := entry
+		file.source = path
+		file.target = filepath.Join(entry.target, rel)
+		file.dir = false
+		markGeneratedEntry(&file)
+		files = append(files, file)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to expand %s: %w", entry.source, err)
+	}
+	return files, nil
+}
+
+// renderFile writes the template source of op rendered for its worktree, with the configured permissions
+// or those of the source
+func (l *linker) renderFile(op linkOp) error {
+	content, err := l.render(op)
+	if err != nil {
+		return err
+	}
+	perm, ok := op.entry.wantPerm()
+	if !ok {
+		info, err := os.Stat(op.entry.source)
+		if err != nil {
+			return err
+		}
+		perm = info.Mode().Perm()
+	}
+	return writeFile(op.target, bytes.NewReader(content), perm)
+}
+
+// copyFile copies src to dst through a temporary file, so dst is never left half written.
+// dst gets perm, or the permissions of src when perm is 0.
+func copyFile(src, dst string, perm os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	if perm == 0 {
+		perm = info.Mode().Perm()
+	}
+	return writeFile(dst, in, perm)
+}
+
+// writeFile writes r to dst through a temporary file, so dst is never left half written
+func writeFile(dst string, r io.Reader, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(dst), ".toolbox-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op once renamed
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), dst)
+}
+
+// hashFile returns the hex encoded sha256 of the file content
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
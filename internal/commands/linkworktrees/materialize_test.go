@@ -0,0 +1,274 @@
+package linkworktrees
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseLinkMode(t *testing.T) {
+	for _, valid := range []string{"symlink", "relative-symlink", "hardlink", "copy"} {
+		if _, err := parseLinkMode(valid); err != nil {
+			t.Errorf("parseLinkMode(%q) unexpected error = %v", valid, err)
+		}
+	}
+	if _, err := parseLinkMode("junction"); err == nil {
+		t.Error("parseLinkMode() expected error for unknown mode")
+	}
+}
+
+func TestLinkerCopyLifecycle(t *testing.T) {
+	tmpDir := t.TempDir()
+	linksDir := filepath.Join(tmpDir, "links")
+	worktree := filepath.Join(tmpDir, "worktree")
+	source := filepath.Join(linksDir, "conf", "app.env")
+
+	if err := os.MkdirAll(filepath.Dir(source), 0755); err != nil {
+		t.Fatalf("failed to create dir: %v", err)
+	}
+	if err := os.WriteFile(source, []byte("PORT=1"), 0644); err != nil {
+		t.Fatalf("failed to create file: %v", err)
+	}
+
+	records, err := loadRecords(filepath.Join(tmpDir, "records.json"))
+	if err != nil {
+		t.Fatalf("loadRecords() error = %v", err)
+	}
+	l := &linker{linksDirs: []string{linksDir}, records: records, now: time.Now()}
+	op := linkOp{
+		entry:    linkEntry{source: source, target: "conf/app.env", mode: modeCopy},
+		worktree: worktree,
+		target:   filepath.Join(worktree, "conf", "app.env"),
+	}
+
+	assertState := func(want linkState) {
+		t.Helper()
+		got, err := l.check(op)
+		if err != nil {
+			t.Fatalf("check() error = %v", err)
+		}
+		if got.State != want {
+			t.Fatalf("check() state = %v, want %v", got.State, want)
+		}
+	}
+
+	assertState(stateMissing)
+	if err := l.materialize(op); err != nil {
+		t.Fatalf("materialize() error = %v", err)
+	}
+	assertState(stateLinked)
+
+	// Source changed: our own copy is outdated and safe to replace
+	if err := os.WriteFile(source, []byte("PORT=2"), 0644); err != nil {
+		t.Fatalf("failed to update source: %v", err)
+	}
+	assertState(stateStale)
+
+	// Copy modified by hand: it is no longer ours to replace
+	if err := os.WriteFile(op.target, []byte("PORT=3"), 0644); err != nil {
+		t.Fatalf("failed to update target: %v", err)
+	}
+	assertState(stateShadowed)
+
+	if err := l.records.save(); err != nil {
+		t.Fatalf("save() error = %v", err)
+	}
+	reloaded, err := loadRecords(records.path)
+	if err != nil {
+		t.Fatalf("loadRecords() error = %v", err)
+	}
+	if reloaded.Entries[op.target].Source != source {
+		t.Errorf("loadRecords() lost the record for %s", op.target)
+	}
+}
+
+func TestLinkerHardlinkEditedSource(t *testing.T) {
+	tmpDir := t.TempDir()
+	source := filepath.Join(tmpDir, "links", "app.env")
+	worktree := filepath.Join(tmpDir, "worktree")
+	writeFiles(t, filepath.Dir(source), map[string]string{"app.env": "PORT=1"})
+
+	records, err := loadRecords("")
+	if err != nil {
+		t.Fatalf("loadRecords() error = %v", err)
+	}
+	l := &linker{linksDirs: []string{filepath.Dir(source)}, records: records, now: time.Now()}
+	op := linkOp{
+		entry:    linkEntry{source: source, target: "app.env", mode: modeHardlink},
+		worktree: worktree,
+		target:   filepath.Join(worktree, "app.env"),
+	}
+	if err := l.materialize(op); err != nil {
+		t.Fatalf("materialize() error = %v", err)
+	}
+
+	// Editing the source edits the hardlink too, which stays ours even though its content changed
+	if err := os.WriteFile(source, []byte("PORT=2"), 0644); err != nil {
+		t.Fatalf("failed to update source: %v", err)
+	}
+	if ok, err := isRecorded(op.target, records); err != nil || !ok {
+		t.Errorf("isRecorded() = %v, %v, want true", ok, err)
+	}
+
+	// Switching to symlinks replaces it
+	op.entry.mode = modeSymlink
+	got, err := l.check(op)
+	if err != nil {
+		t.Fatalf("check() error = %v", err)
+	}
+	if got.State != stateStale {
+		t.Errorf("check() state = %v, want %v", got.State, stateStale)
+	}
+}
+
+func TestLinkerRelativeSymlink(t *testing.T) {
+	tmpDir := t.TempDir()
+	source := filepath.Join(tmpDir, "links", "file.txt")
+	worktree := filepath.Join(tmpDir, "worktree")
+
+	if err := os.MkdirAll(filepath.Dir(source), 0755); err != nil {
+		t.Fatalf("failed to create dir: %v", err)
+	}
+	if err := os.WriteFile(source, []byte("test"), 0644); err != nil {
+		t.Fatalf("failed to create file: %v", err)
+	}
+
+	l := &linker{linksDirs: []string{filepath.Dir(source)}, records: &linkRecords{Entries: map[string]linkRecord{}}}
+	op := linkOp{
+		entry:    linkEntry{source: source, target: "sub/file.txt", mode: modeRelativeSymlink},
+		worktree: worktree,
+		target:   filepath.Join(worktree, "sub", "file.txt"),
+	}
+
+	if err := l.materialize(op); err != nil {
+		t.Fatalf("materialize() error = %v", err)
+	}
+
+	dest, err := os.Readlink(op.target)
+	if err != nil {
+		t.Fatalf("failed to read link: %v", err)
+	}
+	if want := filepath.Join("..", "..", "links", "file.txt"); dest != want {
+		t.Errorf("materialize() link = %q, want %q", dest, want)
+	}
+}
+
+func TestHasLinkForm(t *testing.T) {
+	tmpDir := t.TempDir()
+	source := filepath.Join(tmpDir, "links", "file.txt")
+
+	if err := os.Symlink(source, filepath.Join(tmpDir, "absolute")); err != nil {
+		t.Fatalf("failed to create symlink: %v", err)
+	}
+	if err := os.Symlink(filepath.Join("links", "file.txt"), filepath.Join(tmpDir, "relative")); err != nil {
+		t.Fatalf("failed to create symlink: %v", err)
+	}
+
+	tests := []struct {
+		link string
+		mode linkMode
+		want bool
+	}{
+		{"absolute", modeSymlink, true},
+		{"absolute", modeRelativeSymlink, false},
+		{"relative", modeSymlink, false},
+		{"relative", modeRelativeSymlink, true},
+	}
+
+	for _, tt := range tests {
+		op := linkOp{entry: linkEntry{source: source, mode: tt.mode}, worktree: tmpDir, target: filepath.Join(tmpDir, tt.link)}
+		got, err := hasLinkForm(op)
+		if err != nil {
+			t.Fatalf("hasLinkForm() error = %v", err)
+		}
+		if got != tt.want {
+			t.Errorf("hasLinkForm(%s, %s) = %v, want %v", tt.link, tt.mode, got, tt.want)
+		}
+	}
+}
+
+func TestLinkerSymlinkedParent(t *testing.T) {
+	tmpDir := t.TempDir()
+	linksDir := filepath.Join(tmpDir, "links")
+	worktree := filepath.Join(tmpDir, "worktree")
+	source := filepath.Join(linksDir, ".claude", "settings.json")
+
+	if err := os.MkdirAll(filepath.Dir(source), 0755); err != nil {
+		t.Fatalf("failed to create dir: %v", err)
+	}
+	if err := os.MkdirAll(worktree, 0755); err != nil {
+		t.Fatalf("failed to create dir: %v", err)
+	}
+	if err := os.WriteFile(source, []byte("{}"), 0644); err != nil {
+		t.Fatalf("failed to create file: %v", err)
+	}
+	// A previous run linked .claude as a whole
+	if err := os.Symlink(filepath.Dir(source), filepath.Join(worktree, ".claude")); err != nil {
+		t.Fatalf("failed to create symlink: %v", err)
+	}
+
+	l := &linker{linksDirs: []string{linksDir}, records: &linkRecords{Entries: map[string]linkRecord{}}}
+	op := linkOp{
+		entry:    linkEntry{source: source, target: ".claude/settings.json", mode: modeCopy},
+		worktree: worktree,
+		target:   filepath.Join(worktree, ".claude", "settings.json"),
+	}
+
+	status, err := l.check(op)
+	if err != nil {
+		t.Fatalf("check() error = %v", err)
+	}
+	if status.State != stateStale || status.parent != filepath.Join(worktree, ".claude") {
+		t.Fatalf("check() = %+v, want stale through the .claude symlink", status)
+	}
+
+	if err := clearTarget(nil, io.Discard, op, status, []string{linksDir}, policyOverwrite, time.Now()); err != nil {
+		t.Fatalf("clearTarget() error = %v", err)
+	}
+	if _, err := os.Stat(source); err != nil {
+		t.Fatalf("clearTarget() removed the source behind the symlink: %v", err)
+	}
+	if err := l.materialize(op); err != nil {
+		t.Fatalf("materialize() error = %v", err)
+	}
+
+	info, err := os.Lstat(filepath.Join(worktree, ".claude"))
+	if err != nil || !info.IsDir() {
+		t.Errorf("materialize() did not replace the directory symlink with a real directory")
+	}
+}
+
+func TestExpandDirEntries(t *testing.T) {
+	tmpDir := t.TempDir()
+	for _, file := range []string{"a.json", "nested/.hidden"} {
+		path := filepath.Join(tmpDir, ".claude", file)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("failed to create dir: %v", err)
+		}
+		if err := os.WriteFile(path, []byte("test"), 0644); err != nil {
+			t.Fatalf("failed to create file: %v", err)
+		}
+	}
+
+	entries := []linkEntry{
+		{source: filepath.Join(tmpDir, ".claude"), target: ".claude", dir: true, mode: modeCopy},
+		{source: filepath.Join(tmpDir, ".claude"), target: ".other", dir: true, mode: modeSymlink},
+	}
+
+	got, err := expandDirEntries(entries)
+	if err != nil {
+		t.Fatalf("expandDirEntries() error = %v", err)
+	}
+
+	want := []string{".claude/a.json", ".claude/nested/.hidden", ".other"}
+	if len(got) != len(want) {
+		t.Fatalf("expandDirEntries() returned %d entries, want %d: %+v", len(got), len(want), got)
+	}
+	for i, entry := range got {
+		if filepath.ToSlash(entry.target) != want[i] {
+			t.Errorf("expandDirEntries() entry %d target = %q, want %q", i, entry.target, want[i])
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package linkworktrees
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// defaultDirPerm is the permission of parent directories created for targets when the manifest sets none
+const defaultDirPerm os.FileMode = 0755
+
+// parsePerm parses octal permissions like "0600" or "755" from the manifest
+func parsePerm(s string) (os.FileMode, error) {
+	n, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || n == 0 || n > 0777 {
+		return 0, fmt.Errorf("invalid permissions %q, expected octal like \"0644\"", s)
+	}
+	return os.FileMode(n), nil
+}
+
+// formatPerm formats permissions the way the manifest takes them
+func formatPerm(perm os.FileMode) string {
+	if perm == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%04o", uint32(perm.Perm()))
+}
+
+// wantPerm returns the permissions the file written for the entry must have: the configured ones, or for
+// encrypted sources readable by their owner only. ok is false when they simply follow the source.
+func (e linkEntry) wantPerm() (perm os.FileMode, ok bool) {
+	switch {
+	case e.perm != 0:
+		return e.perm, true
+	case e.mode == modeDecrypt:
+		return decryptedPerm, true
+	default:
+		return 0, false
+	}
+}
+
+// checkPerms rejects permissions on entries that are not written as files of their own. Symlinks have no
+// permissions of their own and changing those of a hardlink changes the source in the links directory too.
+func checkPerms(entries []linkEntry) error {
+	for _, entry := range entries {
+		if entry.perm == 0 {
+			continue
+		}
+		switch entry.mode {
+		case modeCopy, modeTemplate, modeDecrypt:
+		default:
+			return fmt.Errorf("%s: permissions only apply to copies, templates and encrypted sources, not in %s mode",
+				entry.source, entry.mode)
+		}
+	}
+	return nil
+}
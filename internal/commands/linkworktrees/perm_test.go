@@ -0,0 +1,116 @@
+package linkworktrees
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParsePerm(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    os.FileMode
+		wantErr bool
+	}{
+		{in: "0600", want: 0600},
+		{in: "755", want: 0755},
+		{in: "0", wantErr: true},
+		{in: "1777", wantErr: true},
+		{in: "0800", wantErr: true},
+		{in: "rw-r--r--", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := parsePerm(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parsePerm() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parsePerm() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckPerms(t *testing.T) {
+	tests := []struct {
+		name    string
+		entry   linkEntry
+		wantErr bool
+	}{
+		{name: "copy", entry: linkEntry{mode: modeCopy, perm: 0600}},
+		{name: "template", entry: linkEntry{mode: modeTemplate, perm: 0755}},
+		{name: "decrypt", entry: linkEntry{mode: modeDecrypt, perm: 0640}},
+		{name: "symlink without perm", entry: linkEntry{mode: modeSymlink, dirPerm: 0700}},
+		{name: "symlink", entry: linkEntry{mode: modeSymlink, perm: 0600}, wantErr: true},
+		{name: "hardlink", entry: linkEntry{mode: modeHardlink, perm: 0600}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := checkPerms([]linkEntry{tt.entry}); (err != nil) != tt.wantErr {
+				t.Errorf("checkPerms() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLinkerPermLifecycle(t *testing.T) {
+	tmpDir := t.TempDir()
+	linksDir := filepath.Join(tmpDir, "links")
+	worktree := filepath.Join(tmpDir, "worktree")
+	writeFiles(t, linksDir, map[string]string{"bin/setup.sh": "#!/bin/sh\n"})
+	source := filepath.Join(linksDir, "bin", "setup.sh")
+
+	l := &linker{linksDirs: []string{linksDir}, records: &linkRecords{Entries: map[string]linkRecord{}}}
+	op := linkOp{
+		entry:    linkEntry{source: source, target: filepath.Join("scripts", "bin", "setup.sh"), mode: modeCopy, perm: 0750, dirPerm: 0700},
+		worktree: worktree,
+		target:   filepath.Join(worktree, "scripts", "bin", "setup.sh"),
+	}
+
+	assertPerm := func(path string, want os.FileMode) {
+		t.Helper()
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatalf("failed to stat %s: %v", path, err)
+		}
+		if got := info.Mode().Perm(); got != want {
+			t.Errorf("%s has permissions %v, want %v", path, got, want)
+		}
+	}
+
+	if err := os.MkdirAll(worktree, 0755); err != nil {
+		t.Fatalf("failed to create dir: %v", err)
+	}
+	if err := l.materialize(op); err != nil {
+		t.Fatalf("materialize() error = %v", err)
+	}
+	assertPerm(op.target, 0750)
+	assertPerm(filepath.Join(worktree, "scripts"), 0700)
+	assertPerm(filepath.Join(worktree, "scripts", "bin"), 0700)
+
+	status, err := l.check(op)
+	if err != nil {
+		t.Fatalf("check() error = %v", err)
+	}
+	if status.State != stateLinked {
+		t.Fatalf("check() state = %v, want %v", status.State, stateLinked)
+	}
+
+	// Same content with other permissions: our own copy, stale until it is written again
+	if err := os.Chmod(op.target, 0644); err != nil {
+		t.Fatalf("failed to chmod: %v", err)
+	}
+	status, err = l.check(op)
+	if err != nil {
+		t.Fatalf("check() error = %v", err)
+	}
+	if status.State != stateStale || status.Perm != "0644" || status.WantPerm != "0750" {
+		t.Fatalf("check() = %+v, want stale with permissions 0644 instead of 0750", status)
+	}
+	if isConflict(status, l.linksDirs) {
+		t.Errorf("isConflict() = true for a copy with the wrong permissions")
+	}
+}
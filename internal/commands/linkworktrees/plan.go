@@ -0,0 +1,436 @@
+package linkworktrees
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/urfave/cli/v3"
+)
+
+// planAction is what linking does to a single target
+type planAction string
+
+const (
+	planKeep      planAction = "keep"      // already linked, nothing to do
+	planSkip      planAction = "skip"      // a conflict is left in place by --on-conflict=skip
+	planCreate    planAction = "create"    // nothing exists at the target yet
+	planUpdate    planAction = "update"    // one of our own links or copies is outdated and gets replaced
+	planBackup    planAction = "backup"    // a conflict is moved aside before linking
+	planOverwrite planAction = "overwrite" // a conflict is deleted before linking
+)
+
+// Plan output formats of --format, text is the default free-form dry run output
+const (
+	planFormatJSON   = "json"
+	planFormatNDJSON = "ndjson"
+)
+
+// parsePlanFormat validates a --format value, returning an empty format for text
+func parsePlanFormat(s string) (string, error) {
+	switch s {
+	case "", "text":
+		return "", nil
+	case planFormatJSON, planFormatNDJSON:
+		return s, nil
+	default:
+		return "", fmt.Errorf("unsupported format %q, expected text, json or ndjson", s)
+	}
+}
+
+// decideAction returns the action for a target with the checked status. Conflicts under
+// --on-conflict=fail abort the run before any action is decided.
+func decideAction(status linkStatus, linksDirs []string, policy conflictPolicy) planAction {
+	switch {
+	case status.State == stateLinked:
+		return planKeep
+	case status.State == stateMissing:
+		return planCreate
+	case !isConflict(status, linksDirs):
+		return planUpdate
+	case policy == policyBackup:
+		return planBackup
+	case policy == policyOverwrite:
+		return planOverwrite
+	default:
+		return planSkip
+	}
+}
+
+// policy returns the conflict policy clearTarget applies for the action. An update was judged to replace one of
+// our own links when planning, so it is removed even if the links directories changed since.
+func (a planAction) policy() conflictPolicy {
+	switch a {
+	case planBackup:
+		return policyBackup
+	case planUpdate, planOverwrite:
+		return policyOverwrite
+	default:
+		return policyFail
+	}
+}
+
+// valid reports whether a is one of the known actions
+func (a planAction) valid() bool {
+	switch a {
+	case planKeep, planSkip, planCreate, planUpdate, planBackup, planOverwrite:
+		return true
+	default:
+		return false
+	}
+}
+
+// plannedOp is a single op of a machine-readable plan
+type plannedOp struct {
+	Action      planAction  `json:"action"`
+	Mode        linkMode    `json:"mode"`
+	Source      string      `json:"source"`
+	Target      string      `json:"target"` // absolute path of the target
+	Worktree    string      `json:"worktree"`
+	Submodule   string      `json:"submodule,omitempty"`
+	Layer       string      `json:"layer"`
+	Dir         bool        `json:"dir,omitempty"`
+	Perm        string      `json:"perm,omitempty"`
+	DirPerm     string      `json:"dir_perm,omitempty"`
+	State       linkState   `json:"state"`
+	Reason      string      `json:"reason"`
+	Conflict    bool        `json:"conflict"` // the target holds something linkworktrees did not create
+	Fingerprint fingerprint `json:"fingerprint"`
+}
+
+// fingerprint captures the source and target of an op when it was planned, see takeFingerprint
+type fingerprint struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+}
+
+// takeFingerprint describes the source and target of op as they are now. The target is the symlinked
+// parent directory when the target is reached through one.
+func takeFingerprint(op linkOp, status linkStatus) (fingerprint, error) {
+	source, err := stamp(op.entry.source)
+	if err != nil {
+		return fingerprint{}, err
+	}
+	path := op.target
+	if status.parent != "" {
+		path = status.parent
+	}
+	target, err := stamp(path)
+	if err != nil {
+		return fingerprint{}, err
+	}
+	return fingerprint{Source: source, Target: target}, nil
+}
+
+// stamp describes the entry at path without following it: missing, a symlink with its destination,
+// a directory with a digest of everything inside it, or a file with its size, permissions and modification time
+func stamp(path string) (string, error) {
+	info, err := os.Lstat(path)
+	switch {
+	case os.IsNotExist(err):
+		return "missing", nil
+	case err != nil:
+		return "", err
+	case info.Mode()&os.ModeSymlink != 0:
+		dest, err := os.Readlink(path)
+		if err != nil {
+			return "", err
+		}
+		return "symlink " + dest, nil
+	case info.IsDir():
+		digest, err := digestTree(path)
+		if err != nil {
+			return "", err
+		}
+		return "dir " + digest, nil
+	default:
+		return fmt.Sprintf("file %d %s %s", info.Size(), formatPerm(info.Mode().Perm()), info.ModTime().UTC().Format(time.RFC3339Nano)), nil
+	}
+}
+
+// digestTree returns the hex encoded sha256 of the names, types, sizes, permissions and modification times of
+// everything inside the directory at root, and of the destinations of symlinks, so any change inside it shows.
+// Backing up or overwriting the directory would otherwise lose files added after the plan was made.
+func digestTree(root string) (string, error) {
+	h := sha256.New()
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(h, "%s\x00%s %d %s\x00", filepath.ToSlash(rel), info.Mode(), info.Size(), info.ModTime().UTC().Format(time.RFC3339Nano))
+		if info.Mode()&os.ModeSymlink != 0 {
+			dest, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(h, "%s\x00", dest)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to fingerprint %s: %w", root, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// planReason explains the action decided for a target with status
+func planReason(status linkStatus, conflict bool) string {
+	var reason string
+	if status.Perm != "" {
+		return fmt.Sprintf("permissions are %s instead of %s", status.Perm, status.WantPerm)
+	}
+	switch status.State {
+	case stateLinked:
+		return "already linked"
+	case stateMissing:
+		return "nothing exists at the target"
+	case stateShadowed:
+		reason = "a real file or directory is in the way"
+	default:
+		reason = fmt.Sprintf("%s, currently %s", status.State, status.Actual)
+		if conflict {
+			reason += ", not created by linkworktrees"
+		}
+	}
+	if status.parent != "" {
+		reason += fmt.Sprintf(" (reached through symlinked directory %s)", status.parent)
+	}
+	return reason
+}
+
+// writePlan prints the plan as a JSON array, or as one JSON object per line for ndjson
+func writePlan(w io.Writer, format string, l *linker, ops []linkOp, statuses []linkStatus, actions []planAction) error {
+	planned := make([]plannedOp, 0, len(ops))
+	for i, op := range ops {
+		fp, err := takeFingerprint(op, statuses[i])
+		if err != nil {
+			return err
+		}
+		conflict := isConflict(statuses[i], l.linksDirs)
+		planned = append(planned, plannedOp{
+			Action:      actions[i],
+			Mode:        op.entry.mode,
+			Source:      op.entry.source,
+			Target:      op.target,
+			Worktree:    op.worktree,
+			Submodule:   op.submodule,
+			Layer:       op.entry.layer,
+			Dir:         op.entry.dir,
+			Perm:        formatPerm(op.entry.perm),
+			DirPerm:     formatPerm(op.entry.dirPerm),
+			State:       statuses[i].State,
+			Reason:      planReason(statuses[i], conflict),
+			Conflict:    conflict,
+			Fingerprint: fp,
+		})
+	}
+
+	enc := json.NewEncoder(w)
+	if format == planFormatNDJSON {
+		for _, p := range planned {
+			if err := enc.Encode(p); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	enc.SetIndent("", "  ")
+	return enc.Encode(planned)
+}
+
+// readPlan reads a plan written by writePlan in either format
+func readPlan(r io.Reader) ([]plannedOp, error) {
+	br := bufio.NewReader(r)
+	dec := json.NewDecoder(br)
+
+	// A JSON array starts with [, ndjson with the { of its first object
+	first, err := peekNonSpace(br)
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if first == '[' {
+		var planned []plannedOp
+		if err := dec.Decode(&planned); err != nil {
+			return nil, err
+		}
+		return planned, nil
+	}
+
+	var planned []plannedOp
+	for {
+		var p plannedOp
+		if err := dec.Decode(&p); err == io.EOF {
+			return planned, nil
+		} else if err != nil {
+			return nil, err
+		}
+		planned = append(planned, p)
+	}
+}
+
+// peekNonSpace returns the first byte of r that is not white space, without consuming it
+func peekNonSpace(r *bufio.Reader) (byte, error) {
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		if !strings.ContainsRune(" \t\r\n", rune(b)) {
+			return b, r.UnreadByte()
+		}
+	}
+}
+
+// toOp rebuilds the op of a planned op, rejecting targets outside their worktree
+func (p plannedOp) toOp() (linkOp, error) {
+	if !p.Action.valid() {
+		return linkOp{}, fmt.Errorf("unknown action %q for %s", p.Action, p.Target)
+	}
+	if p.Mode != modeTemplate && p.Mode != modeDecrypt {
+		if _, err := parseLinkMode(string(p.Mode)); err != nil {
+			return linkOp{}, fmt.Errorf("%w for %s", err, p.Target)
+		}
+	}
+	if !filepath.IsAbs(p.Source) || !filepath.IsAbs(p.Worktree) {
+		return linkOp{}, fmt.Errorf("source and worktree of %s must be absolute paths", p.Target)
+	}
+	rel, err := filepath.Rel(p.Worktree, p.Target)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return linkOp{}, fmt.Errorf("target %s is not inside worktree %s", p.Target, p.Worktree)
+	}
+	if p.Submodule != "" && !isWithin(p.Worktree, p.Submodule) {
+		return linkOp{}, fmt.Errorf("submodule %s is not inside worktree %s", p.Submodule, p.Worktree)
+	}
+	perm, err := entryPerm(p.Target, p.Perm)
+	if err != nil {
+		return linkOp{}, err
+	}
+	dirPerm, err := entryPerm(p.Target, p.DirPerm)
+	if err != nil {
+		return linkOp{}, err
+	}
+
+	entry := linkEntry{
+		source:  p.Source,
+		target:  rel,
+		dir:     p.Dir,
+		mode:    p.Mode,
+		layer:   p.Layer,
+		perm:    perm,
+		dirPerm: dirPerm,
+	}
+	if err := checkPerms([]linkEntry{entry}); err != nil {
+		return linkOp{}, err
+	}
+	return linkOp{entry: entry, worktree: p.Worktree, target: p.Target, submodule: p.Submodule}, nil
+}
+
+// handleApply executes a plan written by a dry run with --format json or ndjson, exactly as it was reviewed.
+// Nothing is changed when any source or target differs from when the plan was made.
+func handleApply(ctx context.Context, cmd *cli.Command) error {
+	opts, err := newLinkOptions(ctx, cmd)
+	if err != nil {
+		return err
+	}
+
+	path := cmd.String("plan")
+	if path == "" {
+		return errors.New("--plan is required")
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open plan: %w", err)
+	}
+	planned, err := readPlan(f)
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("failed to read plan %s: %w", path, err)
+	}
+	if len(planned) == 0 {
+		fmt.Println("Nothing to apply")
+		return nil
+	}
+
+	// Interrupting rolls back what was linked so far instead of leaving worktrees half linked
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := applyPlan(ctx, opts, path, planned); err != nil {
+		return err
+	}
+	fmt.Println("Plan applied")
+	return nil
+}
+
+// applyPlan executes the ops planned in the plan at path once every source and target was verified to be unchanged
+func applyPlan(ctx context.Context, opts linkOptions, path string, planned []plannedOp) error {
+	ops := make([]linkOp, 0, len(planned))
+	actions := make([]planAction, 0, len(planned))
+	for _, p := range planned {
+		op, err := p.toOp()
+		if err != nil {
+			return fmt.Errorf("invalid plan %s: %w", path, err)
+		}
+		ops = append(ops, op)
+		actions = append(actions, p.Action)
+	}
+
+	// Waits for other runs and rolls back a run that was killed halfway before looking at the worktrees
+	tx, err := beginTransaction(opts.journalPath)
+	if err != nil {
+		return err
+	}
+	defer tx.close()
+	l, err := newLinker(opts)
+	if err != nil {
+		return err
+	}
+	l.tx = tx
+	if err := l.loadTemplateData(ctx, ops); err != nil {
+		return err
+	}
+
+	statuses, err := l.checkAll(ctx, ops, opts.jobs)
+	if err != nil {
+		return err
+	}
+	var changed []string
+	for i, op := range ops {
+		fp, err := takeFingerprint(op, statuses[i])
+		if err != nil {
+			return err
+		}
+		if statuses[i].State != planned[i].State || fp != planned[i].Fingerprint {
+			changed = append(changed, op.target)
+		}
+	}
+	if len(changed) > 0 {
+		return fmt.Errorf("%d targets changed since the plan was made, run the dry run again:\n  %s",
+			len(changed), strings.Join(changed, "\n  "))
+	}
+
+	return l.executePlan(ctx, opts, ops, statuses, actions)
+}
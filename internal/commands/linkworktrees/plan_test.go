@@ -0,0 +1,210 @@
+package linkworktrees
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestDecideAction(t *testing.T) {
+	linksDir := filepath.FromSlash("/repo/links")
+
+	tests := []struct {
+		name   string
+		status linkStatus
+		policy conflictPolicy
+		want   planAction
+	}{
+		{name: "linked", status: linkStatus{State: stateLinked}, policy: policySkip, want: planKeep},
+		{name: "missing", status: linkStatus{State: stateMissing}, policy: policySkip, want: planCreate},
+		{name: "own stale link", status: linkStatus{State: stateStale, Actual: filepath.Join(linksDir, "old")}, policy: policySkip, want: planUpdate},
+		{name: "foreign link skipped", status: linkStatus{State: stateStale, Actual: "/elsewhere"}, policy: policySkip, want: planSkip},
+		{name: "shadowed backed up", status: linkStatus{State: stateShadowed}, policy: policyBackup, want: planBackup},
+		{name: "shadowed overwritten", status: linkStatus{State: stateShadowed}, policy: policyOverwrite, want: planOverwrite},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := decideAction(tt.status, []string{linksDir}, tt.policy); got != tt.want {
+				t.Errorf("decideAction() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteReadPlan(t *testing.T) {
+	tmpDir := t.TempDir()
+	linksDir := filepath.Join(tmpDir, "links")
+	worktree := filepath.Join(tmpDir, "feature")
+	writeFiles(t, linksDir, map[string]string{"a.txt": "a", "conf/b.env": "b"})
+	writeFiles(t, worktree, map[string]string{"a.txt": "mine"})
+
+	entries := []linkEntry{
+		{source: filepath.Join(linksDir, "a.txt"), target: "a.txt", mode: modeSymlink, layer: linksDir},
+		{source: filepath.Join(linksDir, "conf", "b.env"), target: filepath.Join("conf", "b.env"), mode: modeCopy, layer: linksDir},
+	}
+	ops := planLinks(entries, []string{worktree})
+	l := &linker{linksDirs: []string{linksDir}, records: &linkRecords{Entries: map[string]linkRecord{}}}
+	statuses := make([]linkStatus, len(ops))
+	actions := make([]planAction, len(ops))
+	for i, op := range ops {
+		status, err := l.checkForm(op)
+		if err != nil {
+			t.Fatalf("checkForm() error = %v", err)
+		}
+		statuses[i] = status
+		actions[i] = decideAction(status, l.linksDirs, policyBackup)
+	}
+
+	for _, format := range []string{planFormatJSON, planFormatNDJSON} {
+		t.Run(format, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := writePlan(&buf, format, l, ops, statuses, actions); err != nil {
+				t.Fatalf("writePlan() error = %v", err)
+			}
+			planned, err := readPlan(&buf)
+			if err != nil {
+				t.Fatalf("readPlan() error = %v", err)
+			}
+			if len(planned) != len(ops) {
+				t.Fatalf("readPlan() returned %d ops, want %d", len(planned), len(ops))
+			}
+
+			if got := planned[0]; got.Action != planBackup || !got.Conflict || got.State != stateShadowed {
+				t.Errorf("planned a.txt = %+v, want a conflict that is backed up", got)
+			}
+			if got := planned[1]; got.Action != planCreate || got.Conflict || got.Mode != modeCopy {
+				t.Errorf("planned conf/b.env = %+v, want a copy that is created", got)
+			}
+
+			for i, p := range planned {
+				op, err := p.toOp()
+				if err != nil {
+					t.Fatalf("toOp() error = %v", err)
+				}
+				if !reflect.DeepEqual(op, ops[i]) {
+					t.Errorf("toOp() = %+v, want %+v", op, ops[i])
+				}
+				fp, err := takeFingerprint(op, statuses[i])
+				if err != nil {
+					t.Fatalf("takeFingerprint() error = %v", err)
+				}
+				if fp != p.Fingerprint {
+					t.Errorf("fingerprint of unchanged %s = %+v, want %+v", op.target, fp, p.Fingerprint)
+				}
+			}
+		})
+	}
+
+	// Any change to the target shows in its fingerprint
+	before, err := takeFingerprint(ops[0], statuses[0])
+	if err != nil {
+		t.Fatalf("takeFingerprint() error = %v", err)
+	}
+	if err := os.WriteFile(ops[0].target, []byte("changed"), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	after, err := takeFingerprint(ops[0], statuses[0])
+	if err != nil {
+		t.Fatalf("takeFingerprint() error = %v", err)
+	}
+	if before == after {
+		t.Errorf("fingerprint did not change after writing %s: %+v", ops[0].target, after)
+	}
+}
+
+func TestPlannedOpToOpRejectsInvalid(t *testing.T) {
+	valid := plannedOp{
+		Action:   planCreate,
+		Mode:     modeSymlink,
+		Source:   "/repo/links/a.txt",
+		Target:   "/repo-feature/a.txt",
+		Worktree: "/repo-feature",
+	}
+
+	tests := []struct {
+		name   string
+		modify func(p *plannedOp)
+	}{
+		{name: "unknown action", modify: func(p *plannedOp) { p.Action = "delete" }},
+		{name: "unknown mode", modify: func(p *plannedOp) { p.Mode = "move" }},
+		{name: "target outside the worktree", modify: func(p *plannedOp) { p.Target = "/etc/passwd" }},
+		{name: "target is the worktree", modify: func(p *plannedOp) { p.Target = p.Worktree }},
+		{name: "relative source", modify: func(p *plannedOp) { p.Source = "links/a.txt" }},
+		{name: "submodule outside the worktree", modify: func(p *plannedOp) { p.Submodule = "/repo/libs/core" }},
+	}
+
+	if _, err := valid.toOp(); err != nil {
+		t.Fatalf("toOp() error = %v", err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := valid
+			tt.modify(&p)
+			if _, err := p.toOp(); err == nil {
+				t.Errorf("toOp() expected error for %+v", p)
+			}
+		})
+	}
+}
+
+func TestApplyPlanRefusesChangedDirectory(t *testing.T) {
+	tmpDir := t.TempDir()
+	linksDir := filepath.Join(tmpDir, "links")
+	worktree := filepath.Join(tmpDir, "feature")
+	writeFiles(t, linksDir, map[string]string{".claude/settings.json": "shared"})
+	writeFiles(t, worktree, map[string]string{".claude/settings.json": "mine"})
+
+	opts := linkOptions{
+		linksDirs:   []string{linksDir},
+		onConflict:  policyOverwrite,
+		recordsPath: filepath.Join(tmpDir, "links.json"),
+		journalPath: filepath.Join(tmpDir, "journal.json"),
+	}
+	ops := planLinks([]linkEntry{
+		{source: filepath.Join(linksDir, ".claude"), target: ".claude", dir: true, mode: modeSymlink, layer: linksDir},
+	}, []string{worktree})
+
+	makePlan := func() []plannedOp {
+		t.Helper()
+		l := &linker{linksDirs: opts.linksDirs, records: &linkRecords{Entries: map[string]linkRecord{}}}
+		status, err := l.checkForm(ops[0])
+		if err != nil {
+			t.Fatalf("checkForm() error = %v", err)
+		}
+		var buf bytes.Buffer
+		actions := []planAction{decideAction(status, l.linksDirs, opts.onConflict)}
+		if err := writePlan(&buf, planFormatJSON, l, ops, []linkStatus{status}, actions); err != nil {
+			t.Fatalf("writePlan() error = %v", err)
+		}
+		planned, err := readPlan(&buf)
+		if err != nil {
+			t.Fatalf("readPlan() error = %v", err)
+		}
+		if planned[0].Action != planOverwrite {
+			t.Fatalf("planned %+v, want the directory overwritten", planned[0])
+		}
+		return planned
+	}
+
+	// Work added to the directory after the dry run must not be deleted with it
+	planned := makePlan()
+	writeFiles(t, worktree, map[string]string{".claude/new-work": "unsaved"})
+	if err := applyPlan(context.Background(), opts, "plan.json", planned); err == nil {
+		t.Fatal("applyPlan() expected error for a directory changed since the plan was made")
+	}
+	if got, err := os.ReadFile(filepath.Join(worktree, ".claude", "new-work")); err != nil || string(got) != "unsaved" {
+		t.Fatalf("applyPlan() touched the changed directory: %q, %v", got, err)
+	}
+
+	planned = makePlan()
+	if err := applyPlan(context.Background(), opts, "plan.json", planned); err != nil {
+		t.Fatalf("applyPlan() error = %v", err)
+	}
+	if dest, err := os.Readlink(filepath.Join(worktree, ".claude")); err != nil || dest != filepath.Join(linksDir, ".claude") {
+		t.Errorf("applyPlan() linked %q, %v, want a link to %s", dest, err, filepath.Join(linksDir, ".claude"))
+	}
+}
@@ -0,0 +1,485 @@
+package linkworktrees
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// defaultDirs are the directories linked as a whole when neither --dir nor the manifest configure any
+var defaultDirs = []string{".claude"}
+
+// linkOp is a single link to create inside a worktree
+type linkOp struct {
+	entry     linkEntry
+	worktree  string
+	target    string // absolute path inside the worktree
+	submodule string // root of the checked out submodule holding target, see routeSubmodules
+}
+
+// collectEntries resolves what to link, from the manifest links when there are any and by walking the links
+// directories otherwise. The entries of every links directory are overlaid in order, see overlayLinksDirs.
+func collectEntries(opts linkOptions) ([]linkEntry, error) {
+	linksDirs, err := opts.existingLinksDirs()
+	if err != nil {
+		return nil, err
+	}
+
+	manifestPath, err := resolveManifest(opts.manifest, opts.root)
+	if err != nil {
+		return nil, err
+	}
+
+	dirs := defaultDirs
+	ignore := defaultIgnore
+	if opts.targets != nil {
+		ignore = defaultTargetIgnore
+	}
+	mode := opts.mode
+	if manifestPath != "" {
+		manifest, err := loadManifest(manifestPath)
+		if err != nil {
+			return nil, err
+		}
+		if err := manifest.validate(linksDirs); err != nil {
+			return nil, fmt.Errorf("invalid manifest %s:\n%w", manifestPath, err)
+		}
+		if opts.verbose {
+			fmt.Printf("Using manifest %s\n", manifestPath)
+		}
+		if manifest.Mode != "" && !opts.modeSet {
+			mode = linkMode(manifest.Mode)
+		}
+		if len(manifest.Links) > 0 {
+			return collectLayers(linksDirs, mode, manifest.entries)
+		}
+		if manifest.Dirs != nil {
+			dirs = manifest.Dirs
+		}
+		if manifest.Ignore != nil {
+			ignore = manifest.Ignore
+		}
+	}
+	if opts.dirsSet {
+		dirs = opts.dirs
+	}
+
+	if err := checkDirPatterns(dirs); err != nil {
+		return nil, err
+	}
+
+	return collectLayers(linksDirs, mode, func(linksDir string) ([]linkEntry, error) {
+		dirPaths, files, err := scanLinksDir(linksDir, dirs, ignore)
+		if err != nil {
+			return nil, fmt.Errorf("failed to find link files: %w", err)
+		}
+
+		entries := make([]linkEntry, 0, len(dirPaths)+len(files))
+		for _, p := range dirPaths {
+			entry, err := newLinkEntry(linksDir, p, true)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, entry)
+		}
+		for _, p := range files {
+			entry, err := newLinkEntry(linksDir, p, false)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, entry)
+		}
+		return entries, nil
+	})
+}
+
+// collectLayers collects the entries of every links directory with collect, tags them with their
+// directory and overlays them in order
+func collectLayers(linksDirs []string, mode linkMode, collect func(linksDir string) ([]linkEntry, error)) ([]linkEntry, error) {
+	layers := make([][]linkEntry, 0, len(linksDirs))
+	for _, linksDir := range linksDirs {
+		entries, err := collect(linksDir)
+		if err != nil {
+			return nil, err
+		}
+		for i := range entries {
+			entries[i].layer = linksDir
+		}
+		// Layers override each other by the final target, e.g. a personal .env.tmpl replaces the team .env
+		if entries, err = markGenerated(entries); err != nil {
+			return nil, err
+		}
+		layers = append(layers, entries)
+	}
+
+	entries, err := overlayLinksDirs(layers)
+	if err != nil {
+		return nil, err
+	}
+	return resolveModes(entries, mode)
+}
+
+// resolveModes applies the default mode to entries without one, expands directories
+// for modes that cannot materialize them as a whole, marks templates and encrypted sources and checks
+// their permissions can be applied
+func resolveModes(entries []linkEntry, mode linkMode) ([]linkEntry, error) {
+	for i := range entries {
+		if entries[i].mode == "" {
+			entries[i].mode = mode
+		}
+	}
+	expanded, err := expandDirEntries(entries)
+	if err != nil {
+		return nil, err
+	}
+	marked, err := markGenerated(expanded)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkPerms(marked); err != nil {
+		return nil, err
+	}
+	return marked, nil
+}
+
+// newLinker prepares a linker for opts, loading the records of previously materialized files
+func newLinker(opts linkOptions) (*linker, error) {
+	linksDirs := make([]string, 0, len(opts.linksDirs))
+	for _, dir := range opts.linksDirs {
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get absolute path for %s: %w", dir, err)
+		}
+		linksDirs = append(linksDirs, abs)
+	}
+
+	records, err := loadRecords(opts.recordsPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load link records: %w", err)
+	}
+
+	return &linker{
+		repo:      opts.repo,
+		linksDirs: linksDirs,
+		targets:   opts.targets,
+		records:   records,
+		now:       time.Now(),
+		decryptor: &ageDecryptor{identityFiles: opts.identities},
+	}, nil
+}
+
+// newLinkEntry builds the entry for p, which keeps its path relative to linksDir inside the worktree
+func newLinkEntry(linksDir, p string, dir bool) (linkEntry, error) {
+	relPath, err := filepath.Rel(linksDir, p)
+	if err != nil {
+		return linkEntry{}, fmt.Errorf("failed to get relative path for %s: %w", p, err)
+	}
+
+	sourcePath, err := filepath.Abs(p)
+	if err != nil {
+		return linkEntry{}, fmt.Errorf("failed to get absolute path for %s: %w", p, err)
+	}
+
+	return linkEntry{source: sourcePath, target: relPath, dir: dir}, nil
+}
+
+// scanLinksDir walks linksDir and splits it into directories matching dirPatterns, which are linked as a whole,
+// and the remaining individual files. Paths matching the ignore patterns or a .linkignore are skipped,
+// and so is everything inside an ignored directory.
+func scanLinksDir(linksDir string, dirPatterns, ignore []string) (dirs, files []string, err error) {
+	matcher, err := newIgnoreMatcher(ignore)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = filepath.Walk(linksDir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relPath, err := filepath.Rel(linksDir, p)
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			if relPath != "." {
+				if matcher.ignored(relPath, true) {
+					return filepath.SkipDir
+				}
+				if matchDirPattern(dirPatterns, relPath) {
+					dirs = append(dirs, p)
+					return filepath.SkipDir
+				}
+			}
+			return matcher.addFile(p, relPath)
+		}
+
+		if info.Name() == ignoreFileName || matcher.ignored(relPath, false) {
+			return nil
+		}
+
+		files = append(files, p)
+		return nil
+	})
+
+	return dirs, files, err
+}
+
+// matchDirPattern reports whether relPath matches one of the glob patterns
+func matchDirPattern(patterns []string, relPath string) bool {
+	slashed := filepath.ToSlash(relPath)
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(strings.TrimSuffix(pattern, "/"), slashed); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// checkDirPatterns rejects malformed glob patterns up front, since path.Match only reports them lazily
+func checkDirPatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid directory pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// planLinks expands every entry over every worktree, whole directories first
+func planLinks(entries []linkEntry, worktrees []string) []linkOp {
+	sorted := slices.Clone(entries)
+	slices.SortStableFunc(sorted, func(a, b linkEntry) int {
+		switch {
+		case a.dir == b.dir:
+			return 0
+		case a.dir:
+			return -1
+		default:
+			return 1
+		}
+	})
+
+	ops := make([]linkOp, 0, len(sorted)*len(worktrees))
+	for _, entry := range sorted {
+		for _, worktree := range worktrees {
+			ops = append(ops, linkOp{
+				entry:    entry,
+				worktree: worktree,
+				target:   filepath.Join(worktree, entry.target),
+			})
+		}
+	}
+	return ops
+}
+
+// applyLinks executes the plan, printing progress grouped per entry.
+// Conflicts are detected for the whole plan first so that --on-conflict=fail changes nothing,
+// and the changes are made in a transaction that is rolled back when linking fails or is cancelled.
+// Worktrees are checked and linked on up to opts.jobs workers, while the output keeps the plan order.
+// A dry run with --format json or ndjson prints the plan for apply --plan instead.
+func applyLinks(ctx context.Context, opts linkOptions, ops []linkOp) error {
+	var tx *transaction
+	if !opts.dryRun {
+		// Waits for other runs and rolls back a run that was killed halfway before looking at the worktrees
+		var err error
+		if tx, err = beginTransaction(opts.journalPath); err != nil {
+			return err
+		}
+		defer tx.close()
+	}
+	// The records are loaded once the lock is held, other runs may have changed them until then
+	l, err := newLinker(opts)
+	if err != nil {
+		return err
+	}
+	l.tx = tx
+	if err := l.loadTemplateData(ctx, ops); err != nil {
+		return err
+	}
+
+	statuses, err := l.checkAll(ctx, ops, opts.jobs)
+	if err != nil {
+		return err
+	}
+
+	var conflicts []string
+	for i, op := range ops {
+		if isConflict(statuses[i], l.linksDirs) {
+			conflicts = append(conflicts, fmt.Sprintf("%s (%s)", op.target, statuses[i].State))
+		}
+	}
+	if len(conflicts) > 0 && opts.onConflict == policyFail {
+		return fmt.Errorf("%d targets are in the way of links, use --on-conflict to resolve them:\n  %s",
+			len(conflicts), strings.Join(conflicts, "\n  "))
+	}
+
+	actions := make([]planAction, len(ops))
+	for i := range ops {
+		actions[i] = decideAction(statuses[i], l.linksDirs, opts.onConflict)
+	}
+	if opts.dryRun && opts.planFormat != "" {
+		return writePlan(os.Stdout, opts.planFormat, l, ops, statuses, actions)
+	}
+	return l.executePlan(ctx, opts, ops, statuses, actions)
+}
+
+// checkAll checks the status of every op on up to jobs workers
+func (l *linker) checkAll(ctx context.Context, ops []linkOp, jobs int) ([]linkStatus, error) {
+	statuses := make([]linkStatus, len(ops))
+	checked := runPerWorktree(ctx, ops, jobs, func(_ context.Context, i int) error {
+		status, err := l.checkForm(ops[i])
+		statuses[i] = status
+		return err
+	})
+	return statuses, waitResults(checked)
+}
+
+// executePlan carries out the action decided for every op in the transaction of l, or describes it in a dry run
+func (l *linker) executePlan(ctx context.Context, opts linkOptions, ops []linkOp, statuses []linkStatus, actions []planAction) (err error) {
+	// Paths that are in the way of a link in any worktree stay visible to git
+	skipped := 0
+	linked := make(map[excludeTarget]bool)
+	inTheWay := make(map[excludeTarget]bool)
+	recorded := maps.Clone(l.records.Entries)
+	defer func() {
+		if opts.dryRun {
+			return
+		}
+		if err != nil {
+			err = rollbackLinks(l, recorded, err)
+			return
+		}
+		if commitErr := l.tx.commit(); commitErr != nil {
+			err = commitErr
+		}
+		if saveErr := l.records.save(); saveErr != nil && err == nil {
+			err = fmt.Errorf("failed to save link records: %w", saveErr)
+		}
+		if excludeErr := excludeLinked(ctx, opts, l, linked, inTheWay); excludeErr != nil && err == nil {
+			err = excludeErr
+		}
+	}()
+
+	outcomes := make([]linkOutcome, len(ops))
+	results := runPerWorktree(ctx, ops, opts.jobs, func(ctx context.Context, i int) error {
+		return l.execute(ctx, opts, ops[i], statuses[i], actions[i], &outcomes[i])
+	})
+
+	for i, op := range ops {
+		if i == 0 || ops[i-1].entry.target != op.entry.target {
+			if i > 0 {
+				fmt.Println()
+			}
+			switch {
+			case op.entry.dir:
+				fmt.Printf("Linking directory %s to all %s...\n", op.entry.target, opts.targetNoun())
+			case op.entry.mode == modeTemplate:
+				fmt.Printf("Rendering %s into all %s...\n", op.entry.target, opts.targetNoun())
+			case op.entry.mode == modeDecrypt:
+				fmt.Printf("Decrypting %s into all %s...\n", op.entry.target, opts.targetNoun())
+			default:
+				fmt.Printf("Linking %s to all %s...\n", op.entry.target, opts.targetNoun())
+			}
+		}
+
+		// Output is printed in plan order as soon as the op and every op before it finished
+		err := <-results[i]
+		outcome := &outcomes[i]
+		fmt.Print(outcome.output.String())
+		if err != nil {
+			// Stop every worker before the transaction is rolled back
+			if rest := waitResults(results[i+1:]); rest != nil && !errors.Is(rest, context.Canceled) {
+				return rest
+			}
+			return err
+		}
+
+		if outcome.linked {
+			linked[op.excludeTarget()] = true
+		}
+		if outcome.skipped {
+			inTheWay[op.excludeTarget()] = true
+			skipped++
+		}
+	}
+
+	if len(ops) > 0 {
+		fmt.Println()
+	}
+	if skipped > 0 {
+		fmt.Printf("Skipped %d existing targets, use --on-conflict=backup or --on-conflict=overwrite to replace them\n\n", skipped)
+	}
+	return nil
+}
+
+// linkOutcome is what executing one op of the plan did, kept until it can be printed in plan order
+type linkOutcome struct {
+	output  bytes.Buffer
+	linked  bool // the target is linked now
+	skipped bool // a conflict was left in place
+}
+
+// execute carries out action for a single op with the checked status, describing what it did in outcome
+func (l *linker) execute(ctx context.Context, opts linkOptions, op linkOp, status linkStatus, action planAction, outcome *linkOutcome) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	out := &outcome.output
+	switch {
+	case action == planKeep:
+		fmt.Fprintf(out, "  = %s (already linked)\n", op.target)
+		outcome.linked = true
+		return nil
+	case action == planSkip:
+		fmt.Fprintf(out, "  ! Skipped %s (%s)\n", op.target, status.State)
+		outcome.skipped = true
+		return nil
+	case opts.dryRun:
+		switch action {
+		case planBackup:
+			fmt.Fprintf(out, "  Would back up: %s -> %s\n", op.target, backupPath(op.target, l.now))
+		case planOverwrite:
+			fmt.Fprintf(out, "  Would overwrite: %s\n", op.target)
+		}
+		fmt.Fprintf(out, "  Would %s: %s -> %s\n", op.entry.mode.verb(), op.entry.source, op.target)
+		return nil
+	}
+
+	if err := clearTarget(l.tx, out, op, status, l.linksDirs, action.policy(), l.now); err != nil {
+		return err
+	}
+	if err := l.materialize(op); err != nil {
+		return err
+	}
+	outcome.linked = true
+	fmt.Fprintf(out, "  -> %s\n", op.target)
+	return nil
+}
+
+// rollbackLinks undoes the changes of a failed run, so no worktree is left half linked and no replaced entry is lost.
+// The records go back to what they were before the run.
+func rollbackLinks(l *linker, recorded map[string]linkRecord, cause error) error {
+	changes := l.tx.len()
+	if changes == 0 {
+		return cause
+	}
+
+	fmt.Printf("\nRolling back %d changes...\n", changes)
+	if err := l.tx.rollback(); err != nil {
+		return errors.Join(cause, err)
+	}
+	l.records.Entries = recorded
+	fmt.Println("Rolled back, no worktree was changed")
+	return cause
+}
@@ -0,0 +1,124 @@
+package linkworktrees
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestScanLinksDir(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	files := []string{
+		".claude/settings.json",
+		".vscode/settings.json",
+		".idea/workspace.xml",
+		"node_modules/.cache/data.bin",
+		"node_modules/pkg/index.js",
+		"file.txt",
+	}
+	for _, file := range files {
+		path := filepath.Join(tmpDir, file)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("failed to create dir: %v", err)
+		}
+		if err := os.WriteFile(path, []byte("test"), 0644); err != nil {
+			t.Fatalf("failed to create file %s: %v", file, err)
+		}
+	}
+
+	tests := []struct {
+		name      string
+		patterns  []string
+		wantDirs  []string
+		wantFiles []string
+	}{
+		{
+			name:      "default",
+			patterns:  defaultDirs,
+			wantDirs:  []string{".claude"},
+			wantFiles: []string{".idea/workspace.xml", ".vscode/settings.json", "file.txt", "node_modules/.cache/data.bin", "node_modules/pkg/index.js"},
+		},
+		{
+			name:      "nested directory",
+			patterns:  []string{".claude", "node_modules/.cache"},
+			wantDirs:  []string{".claude", "node_modules/.cache"},
+			wantFiles: []string{".idea/workspace.xml", ".vscode/settings.json", "file.txt", "node_modules/pkg/index.js"},
+		},
+		{
+			name:      "glob pattern",
+			patterns:  []string{".[iv]*", "node_modules/*/"},
+			wantDirs:  []string{".idea", ".vscode", "node_modules/.cache", "node_modules/pkg"},
+			wantFiles: []string{".claude/settings.json", "file.txt"},
+		},
+		{
+			name:      "no patterns",
+			wantFiles: []string{".claude/settings.json", ".idea/workspace.xml", ".vscode/settings.json", "file.txt", "node_modules/.cache/data.bin", "node_modules/pkg/index.js"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dirs, files, err := scanLinksDir(tmpDir, tt.patterns, defaultIgnore)
+			if err != nil {
+				t.Fatalf("scanLinksDir() error = %v", err)
+			}
+
+			if got := relPaths(t, tmpDir, dirs); !slices.Equal(got, tt.wantDirs) {
+				t.Errorf("scanLinksDir() dirs = %v, want %v", got, tt.wantDirs)
+			}
+			if got := relPaths(t, tmpDir, files); !slices.Equal(got, tt.wantFiles) {
+				t.Errorf("scanLinksDir() files = %v, want %v", got, tt.wantFiles)
+			}
+		})
+	}
+}
+
+func TestCheckDirPatterns(t *testing.T) {
+	if err := checkDirPatterns([]string{".claude", "node_modules/*"}); err != nil {
+		t.Errorf("checkDirPatterns() unexpected error = %v", err)
+	}
+	if err := checkDirPatterns([]string{"[invalid"}); err == nil {
+		t.Error("checkDirPatterns() expected error for malformed pattern")
+	}
+}
+
+func TestPlanLinks(t *testing.T) {
+	entries := []linkEntry{
+		{source: "/links/a.txt", target: "a.txt"},
+		{source: "/links/.claude", target: ".claude", dir: true},
+		{source: "/links/b.txt", target: "b.txt"},
+	}
+	worktrees := []string{"/wt1", "/wt2"}
+
+	ops := planLinks(entries, worktrees)
+
+	want := []string{
+		"/wt1/.claude", "/wt2/.claude",
+		"/wt1/a.txt", "/wt2/a.txt",
+		"/wt1/b.txt", "/wt2/b.txt",
+	}
+	got := make([]string, 0, len(ops))
+	for _, op := range ops {
+		got = append(got, op.target)
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("planLinks() targets = %v, want %v", got, want)
+	}
+}
+
+// relPaths converts paths to slash-separated paths relative to root
+func relPaths(t *testing.T, root string, paths []string) []string {
+	t.Helper()
+
+	var rel []string
+	for _, p := range paths {
+		r, err := filepath.Rel(root, p)
+		if err != nil {
+			t.Fatalf("failed to get relative path: %v", err)
+		}
+		rel = append(rel, filepath.ToSlash(r))
+	}
+	return rel
+}
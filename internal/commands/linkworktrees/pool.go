@@ -0,0 +1,84 @@
+package linkworktrees
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// runPerWorktree runs fn for every op on up to jobs workers, each taking all ops of one worktree at a time.
+// The ops of a worktree run one after another in plan order, since later ones can depend on what earlier ones
+// cleared or created. It returns a buffered channel per op that receives its error, or nil once it succeeded.
+// After the first failure the ops that did not start yet fail with the context error instead of running.
+func runPerWorktree(ctx context.Context, ops []linkOp, jobs int, fn func(ctx context.Context, i int) error) []chan error {
+	results := make([]chan error, len(ops))
+	for i := range results {
+		results[i] = make(chan error, 1)
+	}
+
+	var order []string
+	groups := make(map[string][]int)
+	for i, op := range ops {
+		if _, ok := groups[op.worktree]; !ok {
+			order = append(order, op.worktree)
+		}
+		groups[op.worktree] = append(groups[op.worktree], i)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	queue := make(chan []int, len(order))
+	for _, worktree := range order {
+		queue <- groups[worktree]
+	}
+	close(queue)
+
+	jobs = max(1, min(jobs, len(order)))
+	var wg sync.WaitGroup
+	for range jobs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for group := range queue {
+				for _, i := range group {
+					if err := ctx.Err(); err != nil {
+						results[i] <- err
+						continue
+					}
+					err := fn(ctx, i)
+					if err != nil {
+						cancel()
+					}
+					results[i] <- err
+				}
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		cancel()
+	}()
+	return results
+}
+
+// waitResults waits for every op and returns the first error in plan order. Errors that only
+// report the cancellation caused by another failure give way to that failure.
+func waitResults(results []chan error) error {
+	var first, cancelled error
+	for _, result := range results {
+		err := <-result
+		switch {
+		case err == nil:
+		case errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded):
+			if cancelled == nil {
+				cancelled = err
+			}
+		case first == nil:
+			first = err
+		}
+	}
+	if first != nil {
+		return first
+	}
+	return cancelled
+}
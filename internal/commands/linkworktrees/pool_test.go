@@ -0,0 +1,106 @@
+package linkworktrees
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+)
+
+func TestRunPerWorktree(t *testing.T) {
+	var ops []linkOp
+	for _, target := range []string{"a", "b", "c"} {
+		for _, worktree := range []string{"/wt1", "/wt2", "/wt3"} {
+			ops = append(ops, linkOp{worktree: worktree, target: filepath.Join(worktree, target)})
+		}
+	}
+
+	var mu sync.Mutex
+	ran := make(map[string][]string)
+	results := runPerWorktree(context.Background(), ops, 2, func(_ context.Context, i int) error {
+		mu.Lock()
+		defer mu.Unlock()
+		ran[ops[i].worktree] = append(ran[ops[i].worktree], filepath.Base(ops[i].target))
+		return nil
+	})
+	if err := waitResults(results); err != nil {
+		t.Fatalf("waitResults() error = %v", err)
+	}
+
+	for _, worktree := range []string{"/wt1", "/wt2", "/wt3"} {
+		if got := ran[worktree]; !slices.Equal(got, []string{"a", "b", "c"}) {
+			t.Errorf("ops of %s ran as %v, want in plan order", worktree, got)
+		}
+	}
+}
+
+func TestRunPerWorktreeStopsOnFailure(t *testing.T) {
+	var ops []linkOp
+	for i := range 4 {
+		ops = append(ops, linkOp{worktree: "/wt", target: fmt.Sprintf("/wt/%d", i)})
+	}
+
+	failure := errors.New("disk full")
+	var mu sync.Mutex
+	var ran []int
+	results := runPerWorktree(context.Background(), ops, 4, func(_ context.Context, i int) error {
+		mu.Lock()
+		ran = append(ran, i)
+		mu.Unlock()
+		if i == 1 {
+			return failure
+		}
+		return nil
+	})
+
+	if err := waitResults(results); !errors.Is(err, failure) {
+		t.Errorf("waitResults() error = %v, want %v", err, failure)
+	}
+	if !slices.Equal(ran, []int{0, 1}) {
+		t.Errorf("ran ops %v, want the ops after the failure skipped", ran)
+	}
+}
+
+func TestApplyLinksConcurrently(t *testing.T) {
+	tmpDir := t.TempDir()
+	linksDir := filepath.Join(tmpDir, "links")
+	writeFiles(t, linksDir, map[string]string{"a.txt": "a", "conf/b.env": "b"})
+
+	var worktrees []string
+	for i := range 8 {
+		worktrees = append(worktrees, filepath.Join(tmpDir, fmt.Sprintf("wt%d", i)))
+	}
+
+	opts := linkOptions{
+		linksDirs:   []string{linksDir},
+		onConflict:  policySkip,
+		recordsPath: filepath.Join(tmpDir, "links.json"),
+		journalPath: filepath.Join(tmpDir, "journal.json"),
+		jobs:        4,
+	}
+	ops := planLinks([]linkEntry{
+		{source: filepath.Join(linksDir, "a.txt"), target: "a.txt", mode: modeSymlink},
+		{source: filepath.Join(linksDir, "conf", "b.env"), target: "conf/b.env", mode: modeCopy},
+	}, worktrees)
+
+	if err := applyLinks(context.Background(), opts, ops); err != nil {
+		t.Fatalf("applyLinks() error = %v", err)
+	}
+
+	records, err := loadRecords(opts.recordsPath)
+	if err != nil {
+		t.Fatalf("loadRecords() error = %v", err)
+	}
+	for _, worktree := range worktrees {
+		if _, err := os.Lstat(filepath.Join(worktree, "a.txt")); err != nil {
+			t.Errorf("applyLinks() did not link a.txt into %s: %v", worktree, err)
+		}
+		if _, ok := records.Entries[filepath.Join(worktree, "conf", "b.env")]; !ok {
+			t.Errorf("applyLinks() did not record the copy in %s", worktree)
+		}
+	}
+}
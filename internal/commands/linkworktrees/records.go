@@ -0,0 +1,244 @@
+package linkworktrees
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// recordsFileName is the file inside the git common dir that tracks materialized entries
+const recordsFileName = "toolbox/links.json"
+
+// recordsPerm keeps the records, which describe the content of decrypted secrets, private to their owner
+const recordsPerm os.FileMode = 0600
+
+// linkRecord remembers a file that was materialized as a hardlink or copy rather than a symlink,
+// so later runs can tell their own outdated copies apart from files a developer created
+type linkRecord struct {
+	Source string   `json:"source"`
+	Mode   linkMode `json:"mode"`
+	Hash   string   `json:"hash"` // digest of the content that was written, see digestFile
+}
+
+// linkRecords is the set of materialized entries, keyed by absolute target path
+type linkRecords struct {
+	path    string
+	Entries map[string]linkRecord `json:"entries"`
+	Dirs    []string              `json:"dirs,omitempty"` // directories created for targets, the only ones pruned once empty
+
+	keyOnce sync.Once
+	key     []byte // secret the digests of decrypted files are keyed with, see secretKey
+	keyErr  error
+}
+
+// loadRecords reads the records at path, returning an empty set if the file does not exist yet
+func loadRecords(path string) (*linkRecords, error) {
+	records := &linkRecords{path: path, Entries: make(map[string]linkRecord)}
+	if path == "" {
+		return records, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return records, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, records); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	if records.Entries == nil {
+		records.Entries = make(map[string]linkRecord)
+	}
+	return records, nil
+}
+
+// save writes the records back to disk
+func (r *linkRecords) save() error {
+	if r.path == "" {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(r.path), 0755); err != nil {
+		return fmt.Errorf("failed to create directory %s: %w", filepath.Dir(r.path), err)
+	}
+
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(r.path, append(data, '\n'), recordsPerm); err != nil {
+		return err
+	}
+	// WriteFile keeps the permissions of records written by earlier versions
+	return os.Chmod(r.path, recordsPerm)
+}
+
+// addDirs remembers dirs as created for targets
+func (r *linkRecords) addDirs(dirs []string) {
+	for _, dir := range dirs {
+		if i, found := slices.BinarySearch(r.Dirs, dir); !found {
+			r.Dirs = slices.Insert(r.Dirs, i, dir)
+		}
+	}
+}
+
+// isCreatedDir reports whether dir was created for a target
+func (r *linkRecords) isCreatedDir(dir string) bool {
+	_, found := slices.BinarySearch(r.Dirs, dir)
+	return found
+}
+
+// dropDir forgets dir once it is removed
+func (r *linkRecords) dropDir(dir string) {
+	if i, found := slices.BinarySearch(r.Dirs, dir); found {
+		r.Dirs = slices.Delete(r.Dirs, i, i+1)
+	}
+}
+
+// digestFile returns the digest recorded for the file at path written in mode: the sha256 of its content, or for
+// decrypted files an HMAC-SHA256 keyed with secretKey. A plain hash of a decrypted secret would let anyone who can
+// read the records guess short secrets like tokens by hashing candidates.
+func (r *linkRecords) digestFile(mode linkMode, path string) (string, error) {
+	if mode != modeDecrypt {
+		return hashFile(path)
+	}
+
+	key, err := r.secretKey()
+	if err != nil {
+		return "", err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	mac := hmac.New(sha256.New, key)
+	if _, err := io.Copy(mac, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// secretKey returns the random key stored next to the records, readable by its owner only, creating it on first
+// use. Records that are not saved get a key that only lives as long as they do.
+func (r *linkRecords) secretKey() ([]byte, error) {
+	r.keyOnce.Do(func() {
+		if r.path == "" {
+			r.key, r.keyErr = newSecretKey()
+			return
+		}
+		r.key, r.keyErr = loadSecretKey(strings.TrimSuffix(r.path, filepath.Ext(r.path)) + ".key")
+	})
+	return r.key, r.keyErr
+}
+
+// loadSecretKey reads the key at path, or generates it there when it does not exist yet
+func loadSecretKey(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		return hex.DecodeString(strings.TrimSpace(string(data)))
+	}
+	if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read records key: %w", err)
+	}
+
+	key, err := newSecretKey()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create directory %s: %w", filepath.Dir(path), err)
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, recordsPerm)
+	if os.IsExist(err) {
+		// Created by a concurrent run
+		return loadSecretKey(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create records key: %w", err)
+	}
+	if _, err := fmt.Fprintln(f, hex.EncodeToString(key)); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to write records key: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return nil, fmt.Errorf("failed to write records key: %w", err)
+	}
+	return key, nil
+}
+
+// newSecretKey returns 32 random bytes
+func newSecretKey() ([]byte, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("failed to generate records key: %w", err)
+	}
+	return key, nil
+}
+
+// isRecorded reports whether the file at target is a recorded hardlink or copy that was not modified since
+func isRecorded(target string, records *linkRecords) (bool, error) {
+	record, ok := records.Entries[target]
+	if !ok {
+		return false, nil
+	}
+
+	return records.holds(target, record)
+}
+
+// holds reports whether the file at target is still the one recorded. A hardlink is, as long as it shares its
+// inode with the source, even after the source was edited in place. Otherwise the content has to be the
+// recorded one, which also covers hardlinks whose source was replaced, e.g. by a checkout.
+func (r *linkRecords) holds(target string, record linkRecord) (bool, error) {
+	if record.Mode == modeHardlink && sameFile(target, record.Source) {
+		return true, nil
+	}
+
+	hash, err := r.digestFile(record.Mode, target)
+	if err != nil {
+		return false, err
+	}
+	return hash == record.Hash, nil
+}
+
+// isRecordedDir reports whether dir only holds recorded hardlinks or copies that were not modified since
+func isRecordedDir(dir string, records *linkRecords) (bool, error) {
+	recorded := true
+
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+
+		ok := false
+		if d.Type().IsRegular() {
+			if ok, err = isRecorded(path, records); err != nil {
+				return err
+			}
+		}
+		if !ok {
+			recorded = false
+			return filepath.SkipAll
+		}
+		return nil
+	})
+
+	return recorded, err
+}
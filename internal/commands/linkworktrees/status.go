@@ -0,0 +1,200 @@
+package linkworktrees
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/urfave/cli/v3"
+)
+
+// linkState describes how a worktree target relates to its expected link
+type linkState string
+
+const (
+	stateLinked   linkState = "linked"
+	stateMissing  linkState = "missing"
+	stateStale    linkState = "stale"
+	stateDangling linkState = "dangling"
+	stateShadowed linkState = "shadowed"
+)
+
+// linkStatus is the state of a single planned link
+type linkStatus struct {
+	Worktree string    `json:"worktree"`
+	Target   string    `json:"target"`
+	Source   string    `json:"source"`
+	Layer    string    `json:"layer"` // links directory the source comes from
+	State    linkState `json:"state"`
+	Actual   string    `json:"actual,omitempty"`    // where an existing symlink points, or the source an outdated copy came from
+	Perm     string    `json:"perm,omitempty"`      // permissions of a copy that differ from the configured ones
+	WantPerm string    `json:"want_perm,omitempty"` // the configured permissions when Perm differs from them
+
+	parent string // symlinked parent directory the target is reached through, if any
+}
+
+// handleStatus reports the link state of every entry in every worktree
+func handleStatus(ctx context.Context, cmd *cli.Command) error {
+	opts, err := newLinkOptions(ctx, cmd)
+	if err != nil {
+		return err
+	}
+	format := cmd.String("format")
+
+	if format != "table" && format != "json" {
+		return fmt.Errorf("unsupported format %q, expected table or json", format)
+	}
+
+	entries, err := collectEntries(opts)
+	if err != nil {
+		return err
+	}
+
+	worktrees, err := opts.selectTargets(ctx)
+	if err != nil {
+		return err
+	}
+
+	l, err := newLinker(opts)
+	if err != nil {
+		return err
+	}
+
+	ops, err := planTargets(ctx, opts, entries, worktrees)
+	if err != nil {
+		return err
+	}
+	if err := l.loadTemplateData(ctx, ops); err != nil {
+		return err
+	}
+
+	statuses := make([]linkStatus, 0, len(ops))
+	drift := 0
+
+	for _, op := range ops {
+		// Check for context cancellation
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+
+		status, err := l.check(op)
+		if err != nil {
+			return err
+		}
+		status.Layer = op.entry.layer
+		if status.State != stateLinked {
+			drift++
+		}
+		statuses = append(statuses, status)
+	}
+
+	if format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(statuses); err != nil {
+			return err
+		}
+	} else {
+		if err := printStatusTable(statuses); err != nil {
+			return err
+		}
+	}
+
+	if drift > 0 {
+		return cli.Exit(fmt.Sprintf("%d of %d links drifted", drift, len(statuses)), 1)
+	}
+	return nil
+}
+
+// checkLink inspects the target of op without following it
+func checkLink(op linkOp) (linkStatus, error) {
+	status := linkStatus{
+		Worktree: op.worktree,
+		Target:   op.entry.target,
+		Source:   op.entry.source,
+	}
+
+	info, err := os.Lstat(op.target)
+	if os.IsNotExist(err) {
+		status.State = stateMissing
+		return status, nil
+	}
+	if err != nil {
+		return status, err
+	}
+
+	if info.Mode()&os.ModeSymlink == 0 {
+		status.State = stateShadowed
+		return status, nil
+	}
+
+	dest, err := readLinkDest(op.target)
+	if err != nil {
+		return status, err
+	}
+
+	// Absolute and relative links are both correct. Comparing the files as well covers
+	// relative links whose directory is reached through a symlink or a bind mount.
+	switch {
+	case dest == op.entry.source || sameFile(op.target, op.entry.source):
+		status.State = stateLinked
+	case !exists(dest):
+		status.State = stateDangling
+		status.Actual = dest
+	default:
+		status.State = stateStale
+		status.Actual = dest
+	}
+	return status, nil
+}
+
+// sameFile reports whether both paths resolve to the same file, following symlinks
+func sameFile(a, b string) bool {
+	infoA, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	infoB, err := os.Stat(b)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(infoA, infoB)
+}
+
+// exists reports whether anything exists at path, following symlinks
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// printStatusTable writes the statuses as an aligned table followed by a summary
+func printStatusTable(statuses []linkStatus) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "WORKTREE\tTARGET\tLAYER\tSTATE\tDETAIL")
+
+	counts := make(map[linkState]int)
+	for _, s := range statuses {
+		counts[s.State]++
+		detail := ""
+		switch {
+		case s.Perm != "":
+			detail = fmt.Sprintf("mode %s, want %s", s.Perm, s.WantPerm)
+		case s.Actual != "":
+			detail = "-> " + s.Actual
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", s.Worktree, s.Target, s.Layer, s.State, detail)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Printf("\nSummary:\n")
+	for _, state := range []linkState{stateLinked, stateMissing, stateStale, stateDangling, stateShadowed} {
+		fmt.Printf("- %-9s %d\n", string(state)+":", counts[state])
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package linkworktrees
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckLink(t *testing.T) {
+	tmpDir := t.TempDir()
+	worktree := filepath.Join(tmpDir, "worktree")
+	source := filepath.Join(tmpDir, "links", "file.txt")
+	other := filepath.Join(tmpDir, "other.txt")
+
+	for _, file := range []string{source, other, filepath.Join(worktree, "shadowed.txt")} {
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatalf("failed to create dir: %v", err)
+		}
+		if err := os.WriteFile(file, []byte("test"), 0644); err != nil {
+			t.Fatalf("failed to create file %s: %v", file, err)
+		}
+	}
+
+	symlinks := map[string]string{
+		"linked.txt":   source,
+		"stale.txt":    other,
+		"dangling.txt": filepath.Join(tmpDir, "gone.txt"),
+		"relative.txt": filepath.Join("..", "links", "file.txt"),
+	}
+	for name, dest := range symlinks {
+		if err := os.Symlink(dest, filepath.Join(worktree, name)); err != nil {
+			t.Fatalf("failed to create symlink %s: %v", name, err)
+		}
+	}
+
+	tests := []struct {
+		target     string
+		wantState  linkState
+		wantActual string
+	}{
+		{"linked.txt", stateLinked, ""},
+		{"relative.txt", stateLinked, ""},
+		{"missing.txt", stateMissing, ""},
+		{"stale.txt", stateStale, other},
+		{"dangling.txt", stateDangling, filepath.Join(tmpDir, "gone.txt")},
+		{"shadowed.txt", stateShadowed, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			op := linkOp{
+				entry:    linkEntry{source: source, target: tt.target},
+				worktree: worktree,
+				target:   filepath.Join(worktree, tt.target),
+			}
+
+			got, err := checkLink(op)
+			if err != nil {
+				t.Fatalf("checkLink() error = %v", err)
+			}
+			if got.State != tt.wantState {
+				t.Errorf("checkLink() state = %v, want %v", got.State, tt.wantState)
+			}
+			if got.Actual != tt.wantActual {
+				t.Errorf("checkLink() actual = %q, want %q", got.Actual, tt.wantActual)
+			}
+		})
+	}
+}
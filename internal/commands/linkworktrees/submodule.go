@@ -0,0 +1,128 @@
+package linkworktrees
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/oscarteg/toolbox/internal/git"
+)
+
+// planTargets plans every entry over every target directory, routing the ops inside submodules when
+// --recurse-submodules is given
+func planTargets(ctx context.Context, opts linkOptions, entries []linkEntry, targets []string) ([]linkOp, error) {
+	ops := planLinks(entries, targets)
+	if !opts.recurseSubmodules {
+		return ops, nil
+	}
+	return routeSubmodules(ctx, ops, opts.verbose)
+}
+
+// routeSubmodules assigns the ops whose target lies inside a checked out submodule of their worktree to that
+// submodule, e.g. links/libs/core/.env goes into the libs/core submodule of every worktree. Ops inside a
+// submodule that is not checked out are dropped, linking there would block git submodule update.
+func routeSubmodules(ctx context.Context, ops []linkOp, verbose bool) ([]linkOp, error) {
+	submodules := make(map[string][]git.Submodule)
+	routed := make([]linkOp, 0, len(ops))
+
+	for _, op := range ops {
+		subs, ok := submodules[op.worktree]
+		if !ok {
+			var err error
+			if subs, err = git.Submodules(ctx, op.worktree); err != nil {
+				return nil, fmt.Errorf("failed to list submodules of %s: %w", op.worktree, err)
+			}
+			submodules[op.worktree] = subs
+		}
+
+		sub, ok := innermostSubmodule(subs, op.entry.target)
+		if !ok {
+			routed = append(routed, op)
+			continue
+		}
+		if !sub.CheckedOut {
+			if verbose {
+				fmt.Printf("Skipping %s, submodule %s is not checked out\n", op.target, sub.Path)
+			}
+			continue
+		}
+		op.submodule = filepath.Join(op.worktree, sub.Path)
+		routed = append(routed, op)
+	}
+	return routed, nil
+}
+
+// innermostSubmodule returns the most deeply nested submodule containing target, both relative to the worktree root.
+// A target at the submodule path itself replaces the submodule and belongs to the worktree.
+func innermostSubmodule(subs []git.Submodule, target string) (git.Submodule, bool) {
+	var found git.Submodule
+	ok := false
+	for _, sub := range subs {
+		root := filepath.FromSlash(sub.Path)
+		if strings.HasPrefix(target, root+string(filepath.Separator)) && (!ok || len(root) > len(found.Path)) {
+			found, ok = sub, true
+		}
+	}
+	return found, ok
+}
+
+// excludeTarget is a linked path as it is listed in an info/exclude file
+type excludeTarget struct {
+	repo   string // submodule root, empty for the info/exclude shared by all worktrees
+	target string // relative to the repo root
+}
+
+// excludeTarget returns where the target of op is excluded from git status
+func (op linkOp) excludeTarget() excludeTarget {
+	if op.submodule == "" {
+		return excludeTarget{target: op.entry.target}
+	}
+	// Both paths are joined onto op.worktree, so Rel cannot fail
+	target, _ := filepath.Rel(op.submodule, op.target)
+	return excludeTarget{repo: op.submodule, target: target}
+}
+
+// repoRoot returns the root of the repository op links into: its submodule, or its worktree
+func (op linkOp) repoRoot() string {
+	if op.submodule != "" {
+		return op.submodule
+	}
+	return op.worktree
+}
+
+// pruneSubmoduleExclude removes the patterns of targets inside the checked out submodules of worktree from
+// their info/exclude. Every submodule checkout has its own git directory, so nothing else shares its patterns.
+func pruneSubmoduleExclude(ctx context.Context, worktree string, targets []string) error {
+	if len(targets) == 0 {
+		return nil
+	}
+	subs, err := git.Submodules(ctx, worktree)
+	if err != nil {
+		return fmt.Errorf("failed to list submodules of %s: %w", worktree, err)
+	}
+
+	remove := make(map[string][]string)
+	for _, target := range targets {
+		sub, ok := innermostSubmodule(subs, target)
+		if !ok || !sub.CheckedOut {
+			continue
+		}
+		rel, err := filepath.Rel(filepath.FromSlash(sub.Path), target)
+		if err != nil {
+			return err
+		}
+		remove[sub.Path] = append(remove[sub.Path], excludePattern(rel))
+	}
+
+	for path, patterns := range remove {
+		excludePath, err := git.GitPath(ctx, filepath.Join(worktree, path), "info/exclude")
+		if err != nil {
+			return err
+		}
+		if err := updateExclude(excludePath, nil, patterns); err != nil {
+			return err
+		}
+	}
+	return nil
+}
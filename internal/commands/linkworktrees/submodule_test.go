@@ -0,0 +1,64 @@
+package linkworktrees
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/oscarteg/toolbox/internal/git"
+)
+
+func TestInnermostSubmodule(t *testing.T) {
+	subs := []git.Submodule{
+		{Path: "libs/core", CheckedOut: true},
+		{Path: "libs/core/vendor/dep", CheckedOut: true},
+		{Path: "docs", CheckedOut: false},
+	}
+
+	tests := []struct {
+		name   string
+		target string
+		want   string
+		wantOK bool
+	}{
+		{name: "outside of submodules", target: ".env", wantOK: false},
+		{name: "inside a submodule", target: "libs/core/.env", want: "libs/core", wantOK: true},
+		{name: "nested submodule", target: "libs/core/vendor/dep/.env", want: "libs/core/vendor/dep", wantOK: true},
+		{name: "not checked out", target: "docs/.env", want: "docs", wantOK: true},
+		{name: "submodule itself", target: "libs/core", wantOK: false},
+		{name: "sibling with the same prefix", target: "libs/core-old/.env", wantOK: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := innermostSubmodule(subs, filepath.FromSlash(tt.target))
+			if ok != tt.wantOK {
+				t.Fatalf("innermostSubmodule() ok = %v, want %v", ok, tt.wantOK)
+			}
+			if got.Path != tt.want {
+				t.Errorf("innermostSubmodule() = %q, want %q", got.Path, tt.want)
+			}
+		})
+	}
+}
+
+func TestLinkOpExcludeTarget(t *testing.T) {
+	worktree := filepath.FromSlash("/repo-feature")
+	entry := linkEntry{target: filepath.FromSlash("libs/core/config/.env")}
+
+	op := linkOp{entry: entry, worktree: worktree, target: filepath.Join(worktree, entry.target)}
+	if got, want := op.excludeTarget(), (excludeTarget{target: entry.target}); got != want {
+		t.Errorf("excludeTarget() = %+v, want %+v", got, want)
+	}
+	if got := op.repoRoot(); got != worktree {
+		t.Errorf("repoRoot() = %q, want %q", got, worktree)
+	}
+
+	op.submodule = filepath.Join(worktree, "libs", "core")
+	want := excludeTarget{repo: op.submodule, target: filepath.Join("config", ".env")}
+	if got := op.excludeTarget(); got != want {
+		t.Errorf("excludeTarget() = %+v, want %+v", got, want)
+	}
+	if got := op.repoRoot(); got != op.submodule {
+		t.Errorf("repoRoot() = %q, want %q", got, op.submodule)
+	}
+}
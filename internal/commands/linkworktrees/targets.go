@@ -0,0 +1,126 @@
+package linkworktrees
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/oscarteg/toolbox/internal/git"
+	"github.com/urfave/cli/v3"
+)
+
+// toFlag returns the flag selecting the target directories of the link command
+func toFlag() cli.Flag {
+	return &cli.StringSliceFlag{
+		Name:    "to",
+		Aliases: []string{"t"},
+		Usage:   "Directory to link into (repeatable, default: targets in the manifest)",
+	}
+}
+
+// hasFlag reports whether cmd defines the flag name
+func hasFlag(cmd *cli.Command, name string) bool {
+	return slices.ContainsFunc(cmd.Flags, func(f cli.Flag) bool {
+		return slices.Contains(f.Names(), name)
+	})
+}
+
+// resolveTargets returns the absolute target directories of the link command: to when given, otherwise the
+// targets of the manifest at manifestPath (or in the current directory), each expanded with expandPath
+func resolveTargets(ctx context.Context, to []string, manifestPath string) ([]string, error) {
+	if len(to) == 0 {
+		m, err := loadManifestIfAny(manifestPath, "")
+		if err != nil {
+			return nil, err
+		}
+		if m != nil {
+			to = m.Targets
+		}
+	}
+	if len(to) == 0 {
+		return nil, errors.New("no target directories, pass --to or list targets in the manifest")
+	}
+
+	targets := make([]string, 0, len(to))
+	for _, dir := range to {
+		expanded, err := expandPath(dir, mainRepoName(ctx, ""))
+		if err != nil {
+			return nil, err
+		}
+		abs, err := filepath.Abs(expanded)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get absolute path for %s: %w", expanded, err)
+		}
+		if !isDir(abs) {
+			return nil, fmt.Errorf("target directory %s does not exist", abs)
+		}
+		if !slices.Contains(targets, abs) {
+			targets = append(targets, abs)
+		}
+	}
+	return targets, nil
+}
+
+// selectTargets returns the directories to link into: the target directories of the link command, or the selected worktrees
+func (o linkOptions) selectTargets(ctx context.Context) ([]string, error) {
+	if o.targets != nil {
+		return o.targets, nil
+	}
+	worktrees, err := getWorktrees(ctx, o.repo, o.worktrees, o.verbose)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get worktrees: %w", err)
+	}
+	return worktrees, nil
+}
+
+// targetNoun names what is linked into in progress output
+func (o linkOptions) targetNoun() string {
+	if o.targets != nil {
+		return "target directories"
+	}
+	return "worktrees"
+}
+
+// stateDir returns the directory keeping the link records and the journal: the git common dir, or for target
+// directories outside of a repository $XDG_STATE_HOME (~/.local/state by default)
+func (o linkOptions) stateDir(ctx context.Context) (string, error) {
+	commonDir, err := git.CommonDir(ctx, o.repo)
+	if err == nil || o.targets == nil {
+		return commonDir, err
+	}
+
+	if dir := os.Getenv("XDG_STATE_HOME"); filepath.IsAbs(dir) {
+		return dir, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("no directory to keep link records in: %w", err)
+	}
+	return filepath.Join(home, ".local", "state"), nil
+}
+
+// loadTargetData prepares the template data of target directories. Index is the position in targets,
+// and targets that are git worktrees get their branch and HEAD.
+func (l *linker) loadTargetData(ctx context.Context, targets []string) {
+	l.templateData = make(map[string]templateData, len(targets))
+	for i, target := range targets {
+		l.templateData[target] = checkoutData(ctx, target, i)
+	}
+}
+
+// checkoutData returns the template data of dir at position index, with the branch and HEAD of dir when it is
+// the root of a git worktree
+func checkoutData(ctx context.Context, dir string, index int) templateData {
+	data := templateData{Path: dir, Name: filepath.Base(dir), Index: index}
+	if worktrees, err := git.ListWorktrees(ctx, dir); err == nil {
+		for _, wt := range worktrees {
+			if samePath(wt.Path, dir) {
+				data.Branch, data.Head, data.Main = wt.BranchName(), wt.Head, wt.Main
+			}
+		}
+	}
+	return data
+}
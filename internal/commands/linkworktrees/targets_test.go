@@ -0,0 +1,106 @@
+package linkworktrees
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestResolveTargets(t *testing.T) {
+	tmpDir := t.TempDir()
+	home, api := filepath.Join(tmpDir, "home"), filepath.Join(tmpDir, "api")
+	for _, dir := range []string{home, api} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("failed to create dir: %v", err)
+		}
+	}
+	t.Setenv("TOOLBOX_TEST_HOME", home)
+
+	manifest := filepath.Join(tmpDir, "toolbox.links.toml")
+	if err := os.WriteFile(manifest, []byte(`targets = ["$TOOLBOX_TEST_HOME", "`+api+`"]`), 0644); err != nil {
+		t.Fatalf("failed to write manifest: %v", err)
+	}
+	empty := filepath.Join(tmpDir, "empty.toml")
+	if err := os.WriteFile(empty, nil, 0644); err != nil {
+		t.Fatalf("failed to write manifest: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		to       []string
+		manifest string
+		want     []string
+		wantErr  bool
+	}{
+		{name: "from the manifest", manifest: manifest, want: []string{home, api}},
+		{name: "--to takes precedence", to: []string{api}, manifest: manifest, want: []string{api}},
+		{name: "duplicates", to: []string{api, api + "/"}, manifest: empty, want: []string{api}},
+		{name: "missing directory", to: []string{filepath.Join(tmpDir, "gone")}, manifest: empty, wantErr: true},
+		{name: "no targets", manifest: empty, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolveTargets(context.Background(), tt.to, tt.manifest)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resolveTargets() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("resolveTargets() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyLinksToTargets(t *testing.T) {
+	tmpDir := t.TempDir()
+	linksDir := filepath.Join(tmpDir, "links")
+	writeFiles(t, linksDir, map[string]string{
+		".bashrc":   "shared",
+		"info.tmpl": "{{.Name}} {{.Index}}",
+		".git/HEAD": "ref: refs/heads/main",
+	})
+	targets := []string{filepath.Join(tmpDir, "home"), filepath.Join(tmpDir, "api")}
+	for _, dir := range targets {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("failed to create dir: %v", err)
+		}
+	}
+
+	opts := linkOptions{
+		linksDirs:   []string{linksDir},
+		root:        tmpDir,
+		mode:        modeSymlink,
+		targets:     targets,
+		onConflict:  policySkip,
+		recordsPath: filepath.Join(tmpDir, "links.json"),
+		journalPath: filepath.Join(tmpDir, "journal.json"),
+		jobs:        2,
+	}
+	entries, err := collectEntries(opts)
+	if err != nil {
+		t.Fatalf("collectEntries() error = %v", err)
+	}
+
+	if err := applyLinks(context.Background(), opts, planLinks(entries, targets)); err != nil {
+		t.Fatalf("applyLinks() error = %v", err)
+	}
+
+	for i, want := range []string{"home 0", "api 1"} {
+		if _, err := os.Lstat(filepath.Join(targets[i], ".bashrc")); err != nil {
+			t.Errorf("applyLinks() did not link .bashrc into %s: %v", targets[i], err)
+		}
+		got, err := os.ReadFile(filepath.Join(targets[i], "info"))
+		if err != nil {
+			t.Fatalf("applyLinks() did not render info into %s: %v", targets[i], err)
+		}
+		if string(got) != want {
+			t.Errorf("rendered info in %s = %q, want %q", targets[i], got, want)
+		}
+		if _, err := os.Lstat(filepath.Join(targets[i], ".git")); !os.IsNotExist(err) {
+			t.Errorf("applyLinks() linked the git metadata of the links directory into %s", targets[i])
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package linkworktrees
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/oscarteg/toolbox/internal/git"
+)
+
+// templateExt marks files in the links directory that are rendered per worktree instead of linked
+const templateExt = ".tmpl"
+
+// templateData is what a template can use about the worktree it is rendered for
+type templateData struct {
+	Path   string // absolute path of the worktree
+	Name   string // base name of the worktree directory
+	Branch string // checked out branch without refs/heads/, empty when detached
+	Head   string // commit checked out in the worktree
+	Index  int    // position in 'git worktree list', the main worktree is 0
+	Main   bool
+}
+
+// templateFuncs are the functions available to templates on top of the text/template builtins
+var templateFuncs = template.FuncMap{
+	"add":     func(a, b int) int { return a + b },
+	"replace": strings.ReplaceAll,
+}
+
+// markGenerated turns file entries with a .tmpl source into template entries and ones with a .age source into
+// decrypt entries, which write to the target without the extension, see markGeneratedEntry
+func markGenerated(entries []linkEntry) ([]linkEntry, error) {
+	targets := make(map[string]string, len(entries))
+
+	for i := range entries {
+		entry := &entries[i]
+		markGeneratedEntry(entry)
+
+		if prev, ok := targets[entry.target]; ok {
+			return nil, fmt.Errorf("%s and %s both link to %s", prev, entry.source, entry.target)
+		}
+		targets[entry.target] = entry.source
+	}
+	return entries, nil
+}
+
+// markGeneratedEntry turns a file entry with a .tmpl or .age source into a template or decrypt entry and strips
+// the extension from its target. Entries that were marked already are left as they are.
+func markGeneratedEntry(entry *linkEntry) {
+	switch {
+	case entry.dir:
+	case strings.HasSuffix(entry.source, templateExt):
+		entry.mode = modeTemplate
+		entry.target = strings.TrimSuffix(entry.target, templateExt)
+	case strings.HasSuffix(entry.source, encryptedExt):
+		entry.mode = modeDecrypt
+		entry.target = strings.TrimSuffix(entry.target, encryptedExt)
+	}
+}
+
+// loadTemplateData looks up the worktree or target directory data templates are rendered with, when ops contain any template
+func (l *linker) loadTemplateData(ctx context.Context, ops []linkOp) error {
+	needed := false
+	for _, op := range ops {
+		if op.entry.mode == modeTemplate {
+			needed = true
+			break
+		}
+	}
+	if !needed {
+		return nil
+	}
+	if l.targets != nil {
+		l.loadTargetData(ctx, l.targets)
+	} else {
+		worktrees, err := git.ListWorktrees(ctx, l.repo)
+		if err != nil {
+			return fmt.Errorf("failed to get worktrees: %w", err)
+		}
+
+		l.templateData = make(map[string]templateData, len(worktrees))
+		for i, wt := range worktrees {
+			l.templateData[wt.Path] = templateData{
+				Path:   wt.Path,
+				Name:   filepath.Base(wt.Path),
+				Branch: wt.BranchName(),
+				Head:   wt.Head,
+				Index:  i,
+				Main:   wt.Main,
+			}
+		}
+	}
+
+	// A submodule renders with its own path and checkout, and the position of the worktree it is in
+	for _, op := range ops {
+		if op.submodule == "" || op.entry.mode != modeTemplate {
+			continue
+		}
+		if _, ok := l.templateData[op.submodule]; ok {
+			continue
+		}
+		parent, err := l.dataFor(op.worktree)
+		if err != nil {
+			return err
+		}
+		data := checkoutData(ctx, op.submodule, parent.Index)
+		data.Main = parent.Main
+		l.templateData[op.submodule] = data
+	}
+	return nil
+}
+
+// dataFor returns the template data of worktree, which may be spelled differently than git lists it
+func (l *linker) dataFor(worktree string) (templateData, error) {
+	if data, ok := l.templateData[worktree]; ok {
+		return data, nil
+	}
+	for path, data := range l.templateData {
+		if samePath(path, worktree) {
+			return data, nil
+		}
+	}
+	return templateData{}, fmt.Errorf("no template data for worktree %s", worktree)
+}
+
+// render executes the template source of op for its worktree, or its submodule
+func (l *linker) render(op linkOp) ([]byte, error) {
+	data, err := l.dataFor(op.repoRoot())
+	if err != nil {
+		return nil, err
+	}
+	return renderTemplate(op.entry.source, data)
+}
+
+// renderTemplate executes the template file at source, failing on keys that do not exist
+func renderTemplate(source string, data templateData) ([]byte, error) {
+	text, err := os.ReadFile(source)
+	if err != nil {
+		return nil, err
+	}
+
+	tmpl, err := template.New(filepath.Base(source)).Option("missingkey=error").Funcs(templateFuncs).Parse(string(text))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse template %s: %w", source, err)
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return nil, fmt.Errorf("failed to render template %s: %w", source, err)
+	}
+	return buf.Bytes(), nil
+}
+
+// hashBytes returns the hex encoded sha256 of content, matching hashFile
+func hashBytes(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
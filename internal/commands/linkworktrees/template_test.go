@@ -0,0 +1,173 @@
+package linkworktrees
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestRenderTemplate(t *testing.T) {
+	data := templateData{Path: "/src/repo-login", Name: "repo-login", Branch: "feature/login", Head: "abc123", Index: 2}
+
+	tests := []struct {
+		name    string
+		tmpl    string
+		want    string
+		wantErr bool
+	}{
+		{"fields", "{{.Name}} {{.Branch}} {{.Head}} {{.Index}}", "repo-login feature/login abc123 2", false},
+		{"port per worktree", "PORT={{add 3000 .Index}}\n", "PORT=3002\n", false},
+		{"branch slug", `{{replace .Branch "/" "-"}}`, "feature-login", false},
+		{"unknown field", "{{.Port}}", "", true},
+		{"syntax error", "{{.Name", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := filepath.Join(t.TempDir(), "file.tmpl")
+			if err := os.WriteFile(source, []byte(tt.tmpl), 0644); err != nil {
+				t.Fatalf("failed to create template: %v", err)
+			}
+
+			got, err := renderTemplate(source, data)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("renderTemplate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if string(got) != tt.want {
+				t.Errorf("renderTemplate() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMarkTemplates(t *testing.T) {
+	entries, err := markGenerated([]linkEntry{
+		{source: "/links/.env.tmpl", target: ".env.tmpl", mode: modeSymlink},
+		{source: "/links/conf/app.tmpl", target: "app.conf", mode: modeCopy},
+		{source: "/links/.claude", target: ".claude", dir: true, mode: modeSymlink},
+		{source: "/links/README.md", target: "README.md", mode: modeSymlink},
+	})
+	if err != nil {
+		t.Fatalf("markGenerated() error = %v", err)
+	}
+
+	want := []struct {
+		target string
+		mode   linkMode
+	}{
+		{".env", modeTemplate},
+		{"app.conf", modeTemplate},
+		{".claude", modeSymlink},
+		{"README.md", modeSymlink},
+	}
+	for i, w := range want {
+		if entries[i].target != w.target || entries[i].mode != w.mode {
+			t.Errorf("entry %d = %s (%s), want %s (%s)", i, entries[i].target, entries[i].mode, w.target, w.mode)
+		}
+	}
+
+	_, err = markGenerated([]linkEntry{
+		{source: "/links/.env", target: ".env"},
+		{source: "/links/.env.tmpl", target: ".env.tmpl"},
+	})
+	if err == nil {
+		t.Error("markGenerated() expected error when a template and a file share a target")
+	}
+}
+
+func TestLinkerTemplateLifecycle(t *testing.T) {
+	tmpDir := t.TempDir()
+	linksDir := filepath.Join(tmpDir, "links")
+	worktree := filepath.Join(tmpDir, "worktree")
+	source := filepath.Join(linksDir, ".env.tmpl")
+
+	if err := os.MkdirAll(linksDir, 0755); err != nil {
+		t.Fatalf("failed to create dir: %v", err)
+	}
+	if err := os.WriteFile(source, []byte("BRANCH={{.Branch}}\n"), 0600); err != nil {
+		t.Fatalf("failed to create template: %v", err)
+	}
+
+	l := &linker{
+		linksDirs:    []string{linksDir},
+		records:      &linkRecords{Entries: map[string]linkRecord{}},
+		templateData: map[string]templateData{worktree: {Path: worktree, Branch: "main"}},
+	}
+	op := linkOp{
+		entry:    linkEntry{source: source, target: ".env", mode: modeTemplate},
+		worktree: worktree,
+		target:   filepath.Join(worktree, ".env"),
+	}
+
+	assertState := func(want linkState) {
+		t.Helper()
+		got, err := l.check(op)
+		if err != nil {
+			t.Fatalf("check() error = %v", err)
+		}
+		if got.State != want {
+			t.Fatalf("check() state = %v, want %v", got.State, want)
+		}
+	}
+
+	assertState(stateMissing)
+	if err := l.materialize(op); err != nil {
+		t.Fatalf("materialize() error = %v", err)
+	}
+	assertState(stateLinked)
+
+	content, err := os.ReadFile(op.target)
+	if err != nil {
+		t.Fatalf("failed to read rendered file: %v", err)
+	}
+	if string(content) != "BRANCH=main\n" {
+		t.Errorf("rendered %q, want %q", content, "BRANCH=main\n")
+	}
+	if info, err := os.Stat(op.target); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("rendered file does not keep the template permissions: %v", err)
+	}
+
+	// Branch switched: the rendered file is outdated and safe to replace
+	l.templateData[worktree] = templateData{Path: worktree, Branch: "feature"}
+	assertState(stateStale)
+	if err := os.Remove(op.target); err != nil {
+		t.Fatalf("failed to remove rendered file: %v", err)
+	}
+	if err := l.materialize(op); err != nil {
+		t.Fatalf("materialize() error = %v", err)
+	}
+	assertState(stateLinked)
+
+	// Rendered file edited by hand: it is no longer ours to replace
+	if err := os.WriteFile(op.target, []byte("BRANCH=mine\n"), 0600); err != nil {
+		t.Fatalf("failed to update target: %v", err)
+	}
+	assertState(stateShadowed)
+}
+
+func TestCollectEntriesGenerated(t *testing.T) {
+	tmpDir := t.TempDir()
+	linksDir := filepath.Join(tmpDir, "links")
+	writeFiles(t, linksDir, map[string]string{
+		".env.tmpl":     "PORT={{ .Index }}",
+		"app.conf.tmpl": "name={{ .Name }}",
+		".secret.age":   "encrypted",
+		".hidden":       "skipped",
+	})
+
+	opts := linkOptions{linksDirs: []string{linksDir}, root: tmpDir, mode: modeSymlink}
+	entries, err := collectEntries(opts)
+	if err != nil {
+		t.Fatalf("collectEntries() error = %v", err)
+	}
+
+	got := make(map[string]linkMode, len(entries))
+	for _, entry := range entries {
+		got[entry.target] = entry.mode
+	}
+	want := map[string]linkMode{".env": modeTemplate, "app.conf": modeTemplate, ".secret": modeDecrypt}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("collectEntries() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,354 @@
+package linkworktrees
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// journalFileName is the file inside the git common dir that journals a link run while it is in progress
+const journalFileName = "toolbox/journal.json"
+
+// journalAction is a kind of change a transaction can undo
+type journalAction string
+
+const (
+	actionMkdir  journalAction = "mkdir"  // a directory was created
+	actionCreate journalAction = "create" // a link or file was created
+	actionRename journalAction = "rename" // a path was moved, e.g. to a backup
+	actionStash  journalAction = "stash"  // a path was moved aside to be deleted on commit
+)
+
+// journalStep is a single change, recorded before it is made
+type journalStep struct {
+	Action journalAction `json:"action"`
+	Path   string        `json:"path"`
+	To     string        `json:"to,omitempty"` // where a renamed or stashed path was moved to
+}
+
+// transaction journals the changes of a link run, so they can be undone when the run fails or is interrupted.
+// Nothing is deleted before the commit: removed entries are stashed next to their original path instead.
+// All methods work on a nil transaction, making the changes without journaling them, and are safe for concurrent use.
+type transaction struct {
+	path    string   // journal file with one JSON step per line, empty to keep the journal in memory only
+	lock    *os.File // held until close, see beginTransaction
+	mu      sync.Mutex
+	steps   []journalStep
+	journal *os.File // opened on the first step
+}
+
+// beginTransaction starts a transaction journaled at path. It first takes the lock next to the journal, waiting
+// for another run holding it, and then rolls back a run that was interrupted before it finished. The lock is held
+// until close, so concurrent runs neither mix up their journals nor overwrite each other's link records.
+func beginTransaction(path string) (*transaction, error) {
+	if path == "" {
+		return &transaction{}, nil
+	}
+
+	lock, err := acquireLock(strings.TrimSuffix(path, filepath.Ext(path)) + ".lock")
+	if err != nil {
+		return nil, err
+	}
+	t := &transaction{path: path, lock: lock}
+
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		t.close()
+		return nil, fmt.Errorf("failed to read journal: %w", err)
+	}
+	if err == nil {
+		interrupted := &transaction{path: path}
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		for scanner.Scan() {
+			var step journalStep
+			if err := json.Unmarshal(scanner.Bytes(), &step); err != nil {
+				// The last line can be cut short when the run was killed while writing it
+				break
+			}
+			interrupted.steps = append(interrupted.steps, step)
+		}
+		fmt.Printf("Rolling back %d changes of an interrupted run\n\n", len(interrupted.steps))
+		if err := interrupted.rollback(); err != nil {
+			t.close()
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// lockRun takes the lock of beginTransaction for commands that change targets and the records without journaling
+// the changes, rolling back an interrupted run first. Dry runs change nothing and take no lock.
+func lockRun(opts linkOptions) (*transaction, error) {
+	if opts.dryRun {
+		return nil, nil
+	}
+	return beginTransaction(opts.journalPath)
+}
+
+// acquireLock opens the lock file at path and locks it, telling the user when another run holds it
+func acquireLock(path string) (*os.File, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create directory %s: %w", filepath.Dir(path), err)
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock: %w", err)
+	}
+
+	ok, err := tryLockFile(f)
+	if err == nil && !ok {
+		fmt.Println("Waiting for another run to finish...")
+		err = lockFile(f)
+	}
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to lock %s: %w", path, err)
+	}
+	return f, nil
+}
+
+// close releases the lock of the transaction. Changes neither committed nor rolled back stay in the journal,
+// for the next run to roll back.
+func (t *transaction) close() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.journal != nil {
+		t.journal.Close()
+		t.journal = nil
+	}
+	if t.lock != nil {
+		t.lock.Close()
+		t.lock = nil
+	}
+}
+
+// record appends a step to the journal and writes it to disk before the change is made
+func (t *transaction) record(step journalStep) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.steps = append(t.steps, step)
+	if t.path == "" {
+		return nil
+	}
+
+	if t.journal == nil {
+		if err := os.MkdirAll(filepath.Dir(t.path), 0755); err != nil {
+			return fmt.Errorf("failed to create directory %s: %w", filepath.Dir(t.path), err)
+		}
+		f, err := os.OpenFile(t.path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+		if err != nil {
+			return fmt.Errorf("failed to open journal: %w", err)
+		}
+		t.journal = f
+	}
+
+	data, err := json.Marshal(step)
+	if err != nil {
+		return err
+	}
+	if _, err := t.journal.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write journal: %w", err)
+	}
+	return nil
+}
+
+// len returns the number of journaled steps
+func (t *transaction) len() int {
+	if t == nil {
+		return 0
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.steps)
+}
+
+// mkdirAll creates dir and its missing parents, journaling each of them, and returns the directories it created.
+// They are created with defaultDirPerm subject to the umask when perm is 0, and with exactly perm otherwise.
+func (t *transaction) mkdirAll(dir string, perm os.FileMode) ([]string, error) {
+	var missing []string
+	for p := dir; ; p = filepath.Dir(p) {
+		if _, err := os.Lstat(p); err == nil || filepath.Dir(p) == p {
+			break
+		}
+		missing = append(missing, p)
+	}
+
+	slices.Reverse(missing)
+	for i, dir := range missing {
+		if t != nil {
+			if err := t.record(journalStep{Action: actionMkdir, Path: dir}); err != nil {
+				return missing[:i], err
+			}
+		}
+		if err := mkdirPerm(dir, perm); err != nil {
+			return missing[:i], err
+		}
+	}
+	return missing, nil
+}
+
+// mkdirPerm creates dir, see mkdirAll for perm
+func mkdirPerm(dir string, perm os.FileMode) error {
+	if perm == 0 {
+		if err := os.Mkdir(dir, defaultDirPerm); err != nil && !os.IsExist(err) {
+			return err
+		}
+		return nil
+	}
+	if err := os.Mkdir(dir, perm); err != nil {
+		// Created by another op in the meantime, which applies its own permissions
+		if os.IsExist(err) {
+			return nil
+		}
+		return err
+	}
+	return os.Chmod(dir, perm)
+}
+
+// create journals path and then calls write to create it. path must not exist yet,
+// a rollback would otherwise remove what was there before.
+func (t *transaction) create(path string, write func() error) error {
+	if t != nil {
+		if _, err := os.Lstat(path); err == nil {
+			return fmt.Errorf("%s already exists", path)
+		}
+		if err := t.record(journalStep{Action: actionCreate, Path: path}); err != nil {
+			return err
+		}
+	}
+	return write()
+}
+
+// rename moves from to to, journaling it
+func (t *transaction) rename(from, to string) error {
+	if t != nil {
+		if err := t.record(journalStep{Action: actionRename, Path: from, To: to}); err != nil {
+			return err
+		}
+	}
+	return os.Rename(from, to)
+}
+
+// remove removes path, or within a transaction moves it aside until the commit.
+// Directories are only removed with all, otherwise only files and symlinks are.
+func (t *transaction) remove(path string, all bool) error {
+	if t == nil {
+		if all {
+			return os.RemoveAll(path)
+		}
+		return os.Remove(path)
+	}
+
+	stash, err := stashPath(path)
+	if err != nil {
+		return err
+	}
+	if info, err := os.Lstat(path); err == nil && info.IsDir() && !all {
+		return fmt.Errorf("%s is a directory", path)
+	}
+	if err := t.record(journalStep{Action: actionStash, Path: path, To: stash}); err != nil {
+		return err
+	}
+	return os.Rename(path, stash)
+}
+
+// commit deletes the stashed entries and the journal, making the changes final
+func (t *transaction) commit() error {
+	if t == nil {
+		return nil
+	}
+
+	var errs []error
+	for _, step := range t.steps {
+		if step.Action == actionStash {
+			if err := os.RemoveAll(step.To); err != nil {
+				errs = append(errs, fmt.Errorf("failed to remove %s: %w", step.To, err))
+			}
+		}
+	}
+	t.steps = nil
+	return errors.Join(append(errs, t.discard())...)
+}
+
+// rollback undoes every journaled change in reverse order, restoring stashed and renamed entries.
+// It keeps going after a failure so as much as possible is restored, and reports all failures.
+func (t *transaction) rollback() error {
+	if t == nil {
+		return nil
+	}
+
+	var errs []error
+	var failed []journalStep
+	for i := len(t.steps) - 1; i >= 0; i-- {
+		step := t.steps[i]
+
+		var err error
+		switch step.Action {
+		case actionMkdir, actionCreate:
+			err = os.Remove(step.Path)
+		case actionRename, actionStash:
+			// The rename may never have happened when the run stopped right after journaling it
+			if _, statErr := os.Lstat(step.To); statErr == nil {
+				err = os.Rename(step.To, step.Path)
+			}
+		}
+		if err != nil && !os.IsNotExist(err) {
+			errs = append(errs, fmt.Errorf("failed to undo %s %s: %w", step.Action, step.Path, err))
+			failed = append([]journalStep{step}, failed...)
+		}
+	}
+
+	t.steps = nil
+	if len(errs) > 0 {
+		// Keep what could not be undone in the journal, so the next run retries only that
+		if err := t.discard(); err != nil {
+			errs = append(errs, err)
+		}
+		for _, step := range failed {
+			if err := t.record(step); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		return fmt.Errorf("failed to roll back:\n%w", errors.Join(errs...))
+	}
+	return t.discard()
+}
+
+// discard closes and removes the journal file
+func (t *transaction) discard() error {
+	if t.path == "" {
+		return nil
+	}
+	if t.journal != nil {
+		t.journal.Close()
+		t.journal = nil
+	}
+	if err := os.Remove(t.path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove journal: %w", err)
+	}
+	return nil
+}
+
+// stashPath returns a free hidden path next to path to move it aside to
+func stashPath(path string) (string, error) {
+	dir, name := filepath.Dir(path), filepath.Base(path)
+	for i := 0; i < 100; i++ {
+		stash := filepath.Join(dir, fmt.Sprintf(".%s.toolbox-stash-%d", name, i))
+		if _, err := os.Lstat(stash); os.IsNotExist(err) {
+			return stash, nil
+		}
+	}
+	return "", fmt.Errorf("no free path to move %s aside", path)
+}
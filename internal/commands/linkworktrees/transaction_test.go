@@ -0,0 +1,235 @@
+package linkworktrees
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeFiles creates every file with its content below dir
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("failed to create dir: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to create file: %v", err)
+		}
+	}
+}
+
+// listFiles returns the names of the entries in dir
+func listFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("failed to read %s: %v", dir, err)
+	}
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	return names
+}
+
+func TestTransactionRollback(t *testing.T) {
+	tmpDir := t.TempDir()
+	worktree := filepath.Join(tmpDir, "worktree")
+	journal := filepath.Join(tmpDir, "journal.json")
+	writeFiles(t, worktree, map[string]string{"replaced.txt": "mine", "backed-up.txt": "mine too"})
+
+	tx, err := beginTransaction(journal)
+	if err != nil {
+		t.Fatalf("beginTransaction() error = %v", err)
+	}
+	newFile := filepath.Join(worktree, "new", "sub", "file.txt")
+	steps := []func() error{
+		func() error { return tx.remove(filepath.Join(worktree, "replaced.txt"), false) },
+		func() error {
+			return tx.create(filepath.Join(worktree, "replaced.txt"), func() error {
+				return os.Symlink("/links/replaced.txt", filepath.Join(worktree, "replaced.txt"))
+			})
+		},
+		func() error {
+			return tx.rename(filepath.Join(worktree, "backed-up.txt"), filepath.Join(worktree, "backed-up.txt.bak"))
+		},
+		func() error {
+			_, err := tx.mkdirAll(filepath.Dir(newFile), 0)
+			return err
+		},
+		func() error { return tx.create(newFile, func() error { return os.WriteFile(newFile, nil, 0644) }) },
+	}
+	for _, step := range steps {
+		if err := step(); err != nil {
+			t.Fatalf("step error = %v", err)
+		}
+	}
+	if _, err := os.Stat(journal); err != nil {
+		t.Fatalf("transaction did not write its journal: %v", err)
+	}
+
+	if err := tx.rollback(); err != nil {
+		t.Fatalf("rollback() error = %v", err)
+	}
+
+	for name, want := range map[string]string{"replaced.txt": "mine", "backed-up.txt": "mine too"} {
+		got, err := os.ReadFile(filepath.Join(worktree, name))
+		if err != nil || string(got) != want {
+			t.Errorf("rollback() left %s = %q, %v, want %q", name, got, err, want)
+		}
+	}
+	if got := listFiles(t, worktree); len(got) != 2 {
+		t.Errorf("rollback() left %v behind, want only the original files", got)
+	}
+	if _, err := os.Stat(journal); !os.IsNotExist(err) {
+		t.Errorf("rollback() kept the journal: %v", err)
+	}
+}
+
+func TestTransactionCommit(t *testing.T) {
+	tmpDir := t.TempDir()
+	// Kept apart, the lock next to the journal stays
+	journal := filepath.Join(t.TempDir(), "journal.json")
+	writeFiles(t, tmpDir, map[string]string{"old/file.txt": "outdated"})
+
+	tx, err := beginTransaction(journal)
+	if err != nil {
+		t.Fatalf("beginTransaction() error = %v", err)
+	}
+	if err := tx.remove(filepath.Join(tmpDir, "old"), true); err != nil {
+		t.Fatalf("remove() error = %v", err)
+	}
+	if err := tx.commit(); err != nil {
+		t.Fatalf("commit() error = %v", err)
+	}
+
+	if got := listFiles(t, tmpDir); len(got) != 0 {
+		t.Errorf("commit() left %v behind", got)
+	}
+}
+
+func TestBeginTransactionRecovers(t *testing.T) {
+	tmpDir := t.TempDir()
+	journal := filepath.Join(tmpDir, "journal.json")
+	writeFiles(t, tmpDir, map[string]string{"file.txt": "mine"})
+	target := filepath.Join(tmpDir, "file.txt")
+
+	// A run that got killed after replacing the file
+	interrupted, err := beginTransaction(journal)
+	if err != nil {
+		t.Fatalf("beginTransaction() error = %v", err)
+	}
+	if err := interrupted.remove(target, false); err != nil {
+		t.Fatalf("remove() error = %v", err)
+	}
+	if err := interrupted.create(target, func() error { return os.Symlink("/links/file.txt", target) }); err != nil {
+		t.Fatalf("create() error = %v", err)
+	}
+	// Like the system does when the process dies
+	interrupted.close()
+
+	tx, err := beginTransaction(journal)
+	if err != nil {
+		t.Fatalf("beginTransaction() error = %v", err)
+	}
+	defer tx.close()
+	got, err := os.ReadFile(target)
+	if err != nil || string(got) != "mine" {
+		t.Errorf("beginTransaction() did not restore the file: %q, %v", got, err)
+	}
+	if _, err := os.Stat(journal); !os.IsNotExist(err) {
+		t.Errorf("beginTransaction() kept the journal of the interrupted run: %v", err)
+	}
+}
+
+func TestBeginTransactionWaitsForOtherRuns(t *testing.T) {
+	tmpDir := t.TempDir()
+	journal := filepath.Join(tmpDir, "journal.json")
+	target := filepath.Join(tmpDir, "file.txt")
+
+	first, err := beginTransaction(journal)
+	if err != nil {
+		t.Fatalf("beginTransaction() error = %v", err)
+	}
+	if err := first.create(target, func() error { return os.WriteFile(target, nil, 0644) }); err != nil {
+		t.Fatalf("create() error = %v", err)
+	}
+
+	began := make(chan error, 1)
+	var second *transaction
+	go func() {
+		var err error
+		second, err = beginTransaction(journal)
+		began <- err
+	}()
+
+	select {
+	case err := <-began:
+		t.Fatalf("beginTransaction() did not wait for the running transaction, error = %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// The journal of the running transaction is not mistaken for one of an interrupted run
+	if err := first.commit(); err != nil {
+		t.Fatalf("commit() error = %v", err)
+	}
+	first.close()
+
+	select {
+	case err := <-began:
+		if err != nil {
+			t.Fatalf("beginTransaction() error = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("beginTransaction() still waits after the running transaction was closed")
+	}
+	defer second.close()
+
+	if _, err := os.Stat(target); err != nil {
+		t.Errorf("beginTransaction() rolled back the committed transaction: %v", err)
+	}
+}
+
+func TestApplyLinksRollsBackOnFailure(t *testing.T) {
+	tmpDir := t.TempDir()
+	linksDir := filepath.Join(tmpDir, "links")
+	worktree := filepath.Join(tmpDir, "worktree")
+	writeFiles(t, linksDir, map[string]string{"a.txt": "shared", "broken/file.txt": ""})
+	writeFiles(t, worktree, map[string]string{"a.txt": "mine"})
+
+	opts := linkOptions{
+		linksDirs:   []string{linksDir},
+		onConflict:  policyOverwrite,
+		recordsPath: filepath.Join(tmpDir, "links.json"),
+		journalPath: filepath.Join(tmpDir, "journal.json"),
+	}
+	ops := planLinks([]linkEntry{
+		{source: filepath.Join(linksDir, "a.txt"), target: "a.txt", mode: modeSymlink},
+		// Copying a directory fails once the first link was made
+		{source: filepath.Join(linksDir, "broken"), target: "sub/b.txt", mode: modeCopy},
+	}, []string{worktree})
+
+	if err := applyLinks(context.Background(), opts, ops); err == nil {
+		t.Fatal("applyLinks() expected error")
+	}
+
+	info, err := os.Lstat(filepath.Join(worktree, "a.txt"))
+	if err != nil || !info.Mode().IsRegular() {
+		t.Fatalf("applyLinks() did not restore the replaced file: %v", err)
+	}
+	if got, _ := os.ReadFile(filepath.Join(worktree, "a.txt")); string(got) != "mine" {
+		t.Errorf("applyLinks() restored %q, want %q", got, "mine")
+	}
+	if got := listFiles(t, worktree); len(got) != 1 {
+		t.Errorf("applyLinks() left %v behind", got)
+	}
+	for _, path := range []string{opts.recordsPath, opts.journalPath} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("applyLinks() left %s behind: %v", path, err)
+		}
+	}
+}
@@ -0,0 +1,337 @@
+package linkworktrees
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/urfave/cli/v3"
+)
+
+// handleUnlink removes every symlink pointing into the links directory, and every unmodified
+// hardlink or copy made by linkworktrees, from all worktrees
+func handleUnlink(ctx context.Context, cmd *cli.Command) error {
+	opts, err := newLinkOptions(ctx, cmd)
+	if err != nil {
+		return err
+	}
+
+	if opts.dryRun {
+		fmt.Println("DRY RUN: No changes will be made")
+		fmt.Println()
+	}
+
+	tx, err := lockRun(opts)
+	if err != nil {
+		return err
+	}
+	defer tx.close()
+
+	l, err := newLinker(opts)
+	if err != nil {
+		return err
+	}
+
+	if opts.targets != nil {
+		if l.planned, err = plannedTargets(opts); err != nil {
+			return err
+		}
+	}
+
+	worktrees, err := opts.selectTargets(ctx)
+	if err != nil {
+		return err
+	}
+
+	if len(worktrees) == 0 {
+		fmt.Printf("No matching %s found\n", opts.targetNoun())
+		return nil
+	}
+
+	count := 0
+	for _, worktree := range worktrees {
+		removed, err := unlinkWorktree(ctx, opts, l, worktree)
+		if err != nil {
+			return err
+		}
+		count += len(removed)
+		if opts.recurseSubmodules && !opts.dryRun {
+			if err := pruneSubmoduleExclude(ctx, worktree, removed); err != nil {
+				return err
+			}
+		}
+	}
+
+	if opts.dryRun {
+		fmt.Printf("Would remove %d links\n", count)
+		return nil
+	}
+
+	if err := l.records.save(); err != nil {
+		return fmt.Errorf("failed to save link records: %w", err)
+	}
+	if err := pruneExclude(ctx, opts, l); err != nil {
+		return err
+	}
+	fmt.Printf("Removed %d links\n", count)
+	return nil
+}
+
+// unlinkWorktree removes the managed paths inside worktree and returns them relative to the worktree
+func unlinkWorktree(ctx context.Context, opts linkOptions, l *linker, worktree string) ([]string, error) {
+	links, err := managedPaths(ctx, l, worktree)
+	if err != nil {
+		return nil, err
+	}
+	if len(links) == 0 {
+		if opts.verbose {
+			fmt.Printf("No links found in %s\n", worktree)
+		}
+		return nil, nil
+	}
+
+	targets := make([]string, 0, len(links))
+	fmt.Printf("Unlinking %s...\n", worktree)
+	for _, link := range links {
+		target, err := filepath.Rel(worktree, link)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, target)
+
+		if opts.dryRun {
+			fmt.Printf("  Would remove: %s\n", link)
+			continue
+		}
+
+		if err := os.Remove(link); err != nil {
+			return nil, fmt.Errorf("failed to remove %s: %w", link, err)
+		}
+		delete(l.records.Entries, link)
+		if err := pruneEmptyDirs(filepath.Dir(link), worktree, l.records); err != nil {
+			return nil, err
+		}
+		fmt.Printf("  x %s\n", link)
+	}
+	fmt.Println()
+	return targets, nil
+}
+
+// managedPaths returns the symlinks into the links directory and the unmodified hardlinks and copies inside worktree
+func managedPaths(ctx context.Context, l *linker, worktree string) ([]string, error) {
+	var links []string
+	var err error
+	if l.targets != nil {
+		links, err = findTargetLinks(worktree, l.planned, l.linksDirs)
+	} else {
+		links, err = findManagedLinks(ctx, worktree, l.linksDirs)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan %s: %w", worktree, err)
+	}
+
+	copies, err := findRecordedFiles(worktree, l.records)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check copies in %s: %w", worktree, err)
+	}
+	return append(links, copies...), nil
+}
+
+// findRecordedFiles returns the recorded hardlinks and copies inside worktree that were not modified since they were written.
+// Records of files that no longer exist are dropped.
+func findRecordedFiles(worktree string, records *linkRecords) ([]string, error) {
+	var files []string
+
+	for target := range records.Entries {
+		if !isWithin(worktree, target) {
+			continue
+		}
+
+		// Never follow a directory symlink into the files on the other side of it
+		parent, err := symlinkedParent(linkOp{worktree: worktree, target: target})
+		if err != nil {
+			return nil, err
+		}
+		if _, err := os.Lstat(target); os.IsNotExist(err) || parent != "" {
+			delete(records.Entries, target)
+			continue
+		}
+
+		ok, err := isRecorded(target, records)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			files = append(files, target)
+		}
+	}
+
+	sort.Strings(files)
+	return files, nil
+}
+
+// findManagedLinks returns the symlinks inside worktree that point into one of linksDirs, without following any symlink
+func findManagedLinks(ctx context.Context, worktree string, linksDirs []string) ([]string, error) {
+	var links []string
+
+	err := filepath.WalkDir(worktree, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		// Check for context cancellation
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+
+		if d.IsDir() {
+			// The links directory itself lives in the main worktree
+			if d.Name() == ".git" || slices.Contains(linksDirs, path) {
+				return filepath.SkipDir
+			}
+			// Worktrees nested inside the main worktree are unlinked on their own
+			if path != worktree && exists(filepath.Join(path, ".git")) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if d.Type()&fs.ModeSymlink == 0 {
+			return nil
+		}
+
+		dest, err := readLinkDest(path)
+		if err != nil {
+			return err
+		}
+		if isWithinAny(linksDirs, dest) {
+			links = append(links, path)
+		}
+		return nil
+	})
+
+	return links, err
+}
+
+// plannedTargets returns the targets of the entries the link command links, none when the links directory is gone
+func plannedTargets(opts linkOptions) ([]string, error) {
+	entries, err := collectEntries(opts)
+	if errors.Is(err, ErrNoLinksDir) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	targets := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		targets = append(targets, entry.target)
+	}
+	return targets, nil
+}
+
+// findTargetLinks returns the symlinks inside dir that point into one of linksDirs, like findManagedLinks, but
+// without walking dir, which for the link command can be all of $HOME. Only the directories leading to the
+// planned targets are read, which also finds the links next to them whose source was removed since.
+func findTargetLinks(dir string, planned, linksDirs []string) ([]string, error) {
+	dirs := make(map[string]bool)
+	for _, target := range planned {
+		for p := filepath.Dir(filepath.Join(dir, target)); !dirs[p]; p = filepath.Dir(p) {
+			dirs[p] = true
+			if p == dir || !isWithin(dir, p) {
+				break
+			}
+		}
+	}
+
+	var links []string
+	for d := range dirs {
+		// Never read the files on the other side of a directory symlink
+		parent, err := symlinkedParent(linkOp{worktree: dir, target: filepath.Join(d, "_")})
+		if err != nil {
+			return nil, err
+		}
+		if parent != "" {
+			continue
+		}
+		entries, err := os.ReadDir(d)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		for _, e := range entries {
+			if e.Type()&fs.ModeSymlink == 0 {
+				continue
+			}
+			path := filepath.Join(d, e.Name())
+			dest, err := readLinkDest(path)
+			if err != nil {
+				return nil, err
+			}
+			if isWithinAny(linksDirs, dest) {
+				links = append(links, path)
+			}
+		}
+	}
+
+	sort.Strings(links)
+	return links, nil
+}
+
+// readLinkDest returns the absolute destination of the symlink at path
+func readLinkDest(path string) (string, error) {
+	dest, err := os.Readlink(path)
+	if err != nil {
+		return "", err
+	}
+	if !filepath.IsAbs(dest) {
+		dest = filepath.Join(filepath.Dir(path), dest)
+	}
+	return filepath.Clean(dest), nil
+}
+
+// isWithin reports whether p is root or a path below it
+func isWithin(root, p string) bool {
+	rel, err := filepath.Rel(root, p)
+	if err != nil {
+		return false
+	}
+	return rel == "." || (rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)))
+}
+
+// pruneEmptyDirs removes dir and its parents while they are empty and were created for targets, stopping at root.
+// Empty directories created by anyone else are kept.
+func pruneEmptyDirs(dir, root string, records *linkRecords) error {
+	for dir != root && isWithin(root, dir) && records.isCreatedDir(dir) {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				records.dropDir(dir)
+				dir = filepath.Dir(dir)
+				continue
+			}
+			return err
+		}
+		if len(entries) > 0 {
+			return nil
+		}
+
+		if err := os.Remove(dir); err != nil {
+			return fmt.Errorf("failed to remove empty directory %s: %w", dir, err)
+		}
+		records.dropDir(dir)
+		dir = filepath.Dir(dir)
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package linkworktrees
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestFindManagedLinks(t *testing.T) {
+	tmpDir := t.TempDir()
+	linksDir := filepath.Join(tmpDir, "links")
+	worktree := filepath.Join(tmpDir, "worktree")
+
+	for _, dir := range []string{filepath.Join(linksDir, "sub"), filepath.Join(worktree, "sub"), filepath.Join(worktree, ".git")} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("failed to create dir %s: %v", dir, err)
+		}
+	}
+	for _, file := range []string{filepath.Join(linksDir, "a.txt"), filepath.Join(linksDir, "sub", "b.txt"), filepath.Join(worktree, "real.txt")} {
+		if err := os.WriteFile(file, []byte("test"), 0644); err != nil {
+			t.Fatalf("failed to create file %s: %v", file, err)
+		}
+	}
+
+	symlinks := map[string]string{
+		filepath.Join(worktree, "a.txt"):        filepath.Join(linksDir, "a.txt"),
+		filepath.Join(worktree, "sub", "b.txt"): filepath.Join("..", "..", "links", "sub", "b.txt"),
+		filepath.Join(worktree, "other"):        filepath.Join(tmpDir, "elsewhere"),
+		filepath.Join(worktree, ".git", "link"): filepath.Join(linksDir, "a.txt"),
+	}
+	for link, dest := range symlinks {
+		if err := os.Symlink(dest, link); err != nil {
+			t.Fatalf("failed to create symlink %s: %v", link, err)
+		}
+	}
+
+	got, err := findManagedLinks(context.Background(), worktree, []string{linksDir})
+	if err != nil {
+		t.Fatalf("findManagedLinks() error = %v", err)
+	}
+
+	want := []string{filepath.Join(worktree, "a.txt"), filepath.Join(worktree, "sub", "b.txt")}
+	if !slices.Equal(got, want) {
+		t.Errorf("findManagedLinks() = %v, want %v", got, want)
+	}
+}
+
+func TestFindTargetLinks(t *testing.T) {
+	tmpDir := t.TempDir()
+	linksDir := filepath.Join(tmpDir, "links")
+	home := filepath.Join(tmpDir, "home")
+	writeFiles(t, linksDir, map[string]string{".bashrc": "", ".config/nvim/init.lua": ""})
+	writeFiles(t, home, map[string]string{"projects/app/README": ""})
+
+	symlinks := map[string]string{
+		".bashrc":               filepath.Join(linksDir, ".bashrc"),
+		".profile":              filepath.Join(linksDir, ".profile"), // source removed since
+		".config/nvim/init.lua": filepath.Join(linksDir, ".config", "nvim", "init.lua"),
+		".config/old":           filepath.Join(linksDir, ".config", "old"),
+		"projects/app/link":     filepath.Join(linksDir, ".bashrc"), // not next to any planned target
+		"other":                 filepath.Join(tmpDir, "elsewhere"),
+	}
+	for link, dest := range symlinks {
+		path := filepath.Join(home, link)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("failed to create dir: %v", err)
+		}
+		if err := os.Symlink(dest, path); err != nil {
+			t.Fatalf("failed to create symlink %s: %v", link, err)
+		}
+	}
+
+	got, err := findTargetLinks(home, []string{".bashrc", filepath.Join(".config", "nvim", "init.lua")}, []string{linksDir})
+	if err != nil {
+		t.Fatalf("findTargetLinks() error = %v", err)
+	}
+
+	var want []string
+	for _, link := range []string{".bashrc", ".config/nvim/init.lua", ".config/old", ".profile"} {
+		want = append(want, filepath.Join(home, link))
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("findTargetLinks() = %v, want %v", got, want)
+	}
+}
+
+func TestPruneEmptyDirs(t *testing.T) {
+	root := t.TempDir()
+
+	nested := filepath.Join(root, "keep", "empty", "deeper")
+	user := filepath.Join(root, "keep", "user")
+	for _, dir := range []string{nested, user} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("failed to create dir: %v", err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(root, "keep", "file.txt"), []byte("test"), 0644); err != nil {
+		t.Fatalf("failed to create file: %v", err)
+	}
+	records := &linkRecords{}
+	records.addDirs([]string{filepath.Join(root, "keep"), filepath.Join(root, "keep", "empty"), nested})
+
+	if err := pruneEmptyDirs(nested, root, records); err != nil {
+		t.Fatalf("pruneEmptyDirs() error = %v", err)
+	}
+	if err := pruneEmptyDirs(user, root, records); err != nil {
+		t.Fatalf("pruneEmptyDirs() error = %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(root, "keep", "empty")); !os.IsNotExist(err) {
+		t.Errorf("pruneEmptyDirs() left empty directory behind")
+	}
+	if _, err := os.Stat(filepath.Join(root, "keep")); err != nil {
+		t.Errorf("pruneEmptyDirs() removed non-empty directory: %v", err)
+	}
+	if _, err := os.Stat(user); err != nil {
+		t.Errorf("pruneEmptyDirs() removed a directory it did not create: %v", err)
+	}
+	if _, err := os.Stat(root); err != nil {
+		t.Errorf("pruneEmptyDirs() removed the root: %v", err)
+	}
+	if want := []string{filepath.Join(root, "keep")}; !slices.Equal(records.Dirs, want) {
+		t.Errorf("pruneEmptyDirs() left records.Dirs = %v, want %v", records.Dirs, want)
+	}
+}
+
+func TestIsWithin(t *testing.T) {
+	tests := []struct {
+		root string
+		path string
+		want bool
+	}{
+		{"/repo/links", "/repo/links", true},
+		{"/repo/links", "/repo/links/a.txt", true},
+		{"/repo/links", "/repo/links-other/a.txt", false},
+		{"/repo/links", "/repo/a.txt", false},
+		{"/repo/links", "/repo/links/../a.txt", false},
+	}
+
+	for _, tt := range tests {
+		if got := isWithin(tt.root, tt.path); got != tt.want {
+			t.Errorf("isWithin(%q, %q) = %v, want %v", tt.root, tt.path, got, tt.want)
+		}
+	}
+}
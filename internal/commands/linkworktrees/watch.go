@@ -0,0 +1,377 @@
+package linkworktrees
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/oscarteg/toolbox/internal/git"
+	"github.com/urfave/cli/v3"
+)
+
+// watcher keeps the selected worktrees linked while the links directories and the worktree list change
+type watcher struct {
+	opts         linkOptions
+	linksDirs    []string // absolute paths of the links directories that exist
+	commonDir    string
+	worktreesDir string // git admin directory holding one entry per linked worktree
+	debounce     time.Duration
+	fsw          *fsnotify.Watcher
+	reported     map[string]bool // conflicts already reported, so they are not repeated on every sync
+}
+
+// handleWatch links everything once, then keeps worktrees in sync until the context is cancelled
+func handleWatch(ctx context.Context, cmd *cli.Command) error {
+	opts, err := newLinkOptions(ctx, cmd)
+	if err != nil {
+		return err
+	}
+
+	policy, err := parseConflictPolicy(cmd.String("on-conflict"))
+	if err != nil {
+		return err
+	}
+	if policy == policyFail {
+		return fmt.Errorf("watch does not support --on-conflict=%s, conflicts are skipped and reported instead", policy)
+	}
+	opts.onConflict = policy
+
+	existing, err := opts.existingLinksDirs()
+	if err != nil {
+		return err
+	}
+	linksDirs := make([]string, 0, len(existing))
+	for _, dir := range existing {
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			return fmt.Errorf("failed to get absolute path for %s: %w", dir, err)
+		}
+		linksDirs = append(linksDirs, abs)
+	}
+	commonDir, err := git.CommonDir(ctx, opts.repo)
+	if err != nil {
+		return err
+	}
+
+	fsw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to start watcher: %w", err)
+	}
+	defer fsw.Close()
+
+	w := &watcher{
+		opts:         opts,
+		linksDirs:    linksDirs,
+		commonDir:    commonDir,
+		worktreesDir: filepath.Join(commonDir, "worktrees"),
+		debounce:     cmd.Duration("debounce"),
+		fsw:          fsw,
+		reported:     make(map[string]bool),
+	}
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	return w.run(ctx)
+}
+
+// run watches until the context is cancelled, syncing once the events of a burst have settled
+func (w *watcher) run(ctx context.Context) error {
+	for _, dir := range w.linksDirs {
+		if err := w.addTree(dir); err != nil {
+			return err
+		}
+	}
+	// The worktrees directory only exists once a worktree was added, so its parent is watched for it to appear
+	if err := w.fsw.Add(w.commonDir); err != nil {
+		return fmt.Errorf("failed to watch %s: %w", w.commonDir, err)
+	}
+	if _, err := os.Stat(w.worktreesDir); err == nil {
+		if err := w.addWorktreesDir(); err != nil {
+			return err
+		}
+	}
+
+	w.sync(ctx, true)
+	fmt.Printf("Watching %s and %s for changes (press Ctrl+C to stop)\n", strings.Join(w.linksDirs, ", "), w.worktreesDir)
+
+	var settle <-chan time.Time
+	prune := false
+
+	for {
+		select {
+		case <-ctx.Done():
+			fmt.Println("Stopped watching")
+			return nil
+
+		case event, ok := <-w.fsw.Events:
+			if !ok {
+				return nil
+			}
+			relevant, removed := w.handleEvent(event)
+			if !relevant {
+				continue
+			}
+			prune = prune || removed
+			settle = time.After(w.debounce)
+
+		case err, ok := <-w.fsw.Errors:
+			if !ok {
+				return nil
+			}
+			fmt.Fprintf(os.Stderr, "Watch error: %v\n", err)
+
+		case <-settle:
+			settle = nil
+			w.sync(ctx, prune)
+			prune = false
+		}
+	}
+}
+
+// handleEvent starts watching new directories and reports whether the event needs a sync,
+// and whether it may have removed a source or a worktree
+func (w *watcher) handleEvent(event fsnotify.Event) (relevant, removed bool) {
+	removed = event.Has(fsnotify.Remove) || event.Has(fsnotify.Rename)
+
+	switch {
+	case isWithinAny(w.linksDirs, event.Name):
+		if event.Has(fsnotify.Create) {
+			if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+				if err := w.addTree(event.Name); err != nil {
+					fmt.Fprintf(os.Stderr, "Watch error: %v\n", err)
+				}
+			}
+		}
+		return event.Op != fsnotify.Chmod, removed
+
+	case event.Name == w.worktreesDir:
+		if event.Has(fsnotify.Create) {
+			if err := w.addWorktreesDir(); err != nil {
+				fmt.Fprintf(os.Stderr, "Watch error: %v\n", err)
+			}
+		}
+		return true, removed
+
+	case filepath.Dir(event.Name) == w.worktreesDir:
+		if event.Has(fsnotify.Create) {
+			// The admin directory of the new worktree holds its HEAD
+			if err := w.fsw.Add(event.Name); err != nil {
+				fmt.Fprintf(os.Stderr, "Watch error: %v\n", err)
+			}
+		}
+		return event.Has(fsnotify.Create) || removed, removed
+
+	case filepath.Base(event.Name) == "HEAD" &&
+		(filepath.Dir(event.Name) == w.commonDir || filepath.Dir(filepath.Dir(event.Name)) == w.worktreesDir):
+		// A worktree switched branches, templates may render differently now
+		return event.Has(fsnotify.Create) || event.Has(fsnotify.Write), false
+
+	default:
+		// Anything else in the git directory, such as index or ref updates
+		return false, false
+	}
+}
+
+// addWorktreesDir watches the worktrees directory and the admin directory of every worktree inside it
+func (w *watcher) addWorktreesDir() error {
+	if err := w.fsw.Add(w.worktreesDir); err != nil {
+		return fmt.Errorf("failed to watch %s: %w", w.worktreesDir, err)
+	}
+
+	dirs, err := os.ReadDir(w.worktreesDir)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", w.worktreesDir, err)
+	}
+	for _, d := range dirs {
+		if !d.IsDir() {
+			continue
+		}
+		adminDir := filepath.Join(w.worktreesDir, d.Name())
+		if err := w.fsw.Add(adminDir); err != nil {
+			return fmt.Errorf("failed to watch %s: %w", adminDir, err)
+		}
+	}
+	return nil
+}
+
+// addTree watches dir and every directory below it
+func (w *watcher) addTree(dir string) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if err := w.fsw.Add(path); err != nil {
+			return fmt.Errorf("failed to watch %s: %w", path, err)
+		}
+		return nil
+	})
+}
+
+// sync links what is missing or outdated, and when prune is set removes links whose source is gone.
+// Errors are reported without stopping the watch, since they are often transient, like a manifest being edited.
+func (w *watcher) sync(ctx context.Context, prune bool) {
+	if err := w.syncLinks(ctx, prune); err != nil && ctx.Err() == nil {
+		fmt.Fprintf(os.Stderr, "Sync failed: %v\n", err)
+	}
+}
+
+// syncLinks brings the selected worktrees up to date, printing only what changed
+func (w *watcher) syncLinks(ctx context.Context, prune bool) (err error) {
+	entries, err := collectEntries(w.opts)
+	if err != nil {
+		return err
+	}
+	worktrees, err := getWorktrees(ctx, w.opts.repo, w.opts.worktrees, w.opts.verbose)
+	if err != nil {
+		return fmt.Errorf("failed to get worktrees: %w", err)
+	}
+
+	tx, err := beginTransaction(w.opts.journalPath)
+	if err != nil {
+		return err
+	}
+	defer tx.close()
+	// Records are reloaded every time under the lock, other runs may have changed them in the meantime
+	l, err := newLinker(w.opts)
+	if err != nil {
+		return err
+	}
+	l.tx = tx
+	linked := make(map[excludeTarget]bool)
+	inTheWay := make(map[excludeTarget]bool)
+	recorded := maps.Clone(l.records.Entries)
+	defer func() {
+		if err != nil {
+			err = rollbackLinks(l, recorded, err)
+			return
+		}
+		if commitErr := l.tx.commit(); commitErr != nil {
+			err = commitErr
+		}
+		if saveErr := l.records.save(); saveErr != nil && err == nil {
+			err = fmt.Errorf("failed to save link records: %w", saveErr)
+		}
+		if excludeErr := excludeLinked(ctx, w.opts, l, linked, inTheWay); excludeErr != nil && err == nil {
+			err = excludeErr
+		}
+	}()
+
+	ops, err := planTargets(ctx, w.opts, entries, worktrees)
+	if err != nil {
+		return err
+	}
+	if err := l.loadTemplateData(ctx, ops); err != nil {
+		return err
+	}
+
+	for _, op := range ops {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		status, err := l.checkForm(op)
+		if err != nil {
+			return err
+		}
+		if status.State == stateLinked {
+			delete(w.reported, op.target)
+			linked[op.excludeTarget()] = true
+			continue
+		}
+		if isConflict(status, l.linksDirs) && w.opts.onConflict == policySkip {
+			inTheWay[op.excludeTarget()] = true
+			if !w.reported[op.target] {
+				fmt.Printf("  ! Skipped %s (%s)\n", op.target, status.State)
+				w.reported[op.target] = true
+			}
+			continue
+		}
+
+		if err := clearTarget(l.tx, os.Stdout, op, status, l.linksDirs, w.opts.onConflict, time.Now()); err != nil {
+			return err
+		}
+		if err := l.materialize(op); err != nil {
+			return err
+		}
+		linked[op.excludeTarget()] = true
+		fmt.Printf("  -> %s\n", op.target)
+	}
+
+	if !prune {
+		return nil
+	}
+	// Rebuilding info/exclude once this returns drops the patterns of the pruned targets
+	for _, worktree := range worktrees {
+		removed, err := pruneOrphans(ctx, l, worktree)
+		if err != nil {
+			return err
+		}
+		if w.opts.recurseSubmodules {
+			if err := pruneSubmoduleExclude(ctx, worktree, removed); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// pruneOrphans removes the links and unmodified copies inside worktree whose source no longer exists,
+// and returns them relative to the worktree
+func pruneOrphans(ctx context.Context, l *linker, worktree string) ([]string, error) {
+	links, err := findManagedLinks(ctx, worktree, l.linksDirs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan %s: %w", worktree, err)
+	}
+
+	var orphans []string
+	for _, link := range links {
+		dest, err := readLinkDest(link)
+		if err != nil {
+			return nil, err
+		}
+		if !exists(dest) {
+			orphans = append(orphans, link)
+		}
+	}
+
+	copies, err := findRecordedFiles(worktree, l.records)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check copies in %s: %w", worktree, err)
+	}
+	for _, target := range copies {
+		if !exists(l.records.Entries[target].Source) {
+			orphans = append(orphans, target)
+		}
+	}
+
+	targets := make([]string, 0, len(orphans))
+	for _, orphan := range orphans {
+		if err := os.Remove(orphan); err != nil {
+			return nil, fmt.Errorf("failed to remove %s: %w", orphan, err)
+		}
+		delete(l.records.Entries, orphan)
+		if err := pruneEmptyDirs(filepath.Dir(orphan), worktree, l.records); err != nil {
+			return nil, err
+		}
+		fmt.Printf("  x %s\n", orphan)
+
+		target, err := filepath.Rel(worktree, orphan)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, target)
+	}
+	return targets, nil
+}
@@ -0,0 +1,130 @@
+package linkworktrees
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+func TestWatcherHandleEvent(t *testing.T) {
+	tmpDir := t.TempDir()
+	linksDir := filepath.Join(tmpDir, "links")
+	commonDir := filepath.Join(tmpDir, ".git")
+	for _, dir := range []string{linksDir, filepath.Join(linksDir, "newdir"), filepath.Join(commonDir, "worktrees", "feature")} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("failed to create dir: %v", err)
+		}
+	}
+
+	fsw, err := fsnotify.NewWatcher()
+	if err != nil {
+		t.Fatalf("failed to start watcher: %v", err)
+	}
+	defer fsw.Close()
+
+	w := &watcher{
+		linksDirs:    []string{linksDir},
+		commonDir:    commonDir,
+		worktreesDir: filepath.Join(commonDir, "worktrees"),
+		fsw:          fsw,
+	}
+
+	tests := []struct {
+		name         string
+		event        fsnotify.Event
+		wantRelevant bool
+		wantRemoved  bool
+	}{
+		{"new source", fsnotify.Event{Name: filepath.Join(linksDir, "a.txt"), Op: fsnotify.Create}, true, false},
+		{"changed source", fsnotify.Event{Name: filepath.Join(linksDir, "a.txt"), Op: fsnotify.Write}, true, false},
+		{"removed source", fsnotify.Event{Name: filepath.Join(linksDir, "a.txt"), Op: fsnotify.Remove}, true, true},
+		{"renamed source", fsnotify.Event{Name: filepath.Join(linksDir, "a.txt"), Op: fsnotify.Rename}, true, true},
+		{"source permissions", fsnotify.Event{Name: filepath.Join(linksDir, "a.txt"), Op: fsnotify.Chmod}, false, false},
+		{"new directory", fsnotify.Event{Name: filepath.Join(linksDir, "newdir"), Op: fsnotify.Create}, true, false},
+		{"new worktree", fsnotify.Event{Name: filepath.Join(commonDir, "worktrees", "feature"), Op: fsnotify.Create}, true, false},
+		{"removed worktree", fsnotify.Event{Name: filepath.Join(commonDir, "worktrees", "feature"), Op: fsnotify.Remove}, true, true},
+		{"worktree admin file", fsnotify.Event{Name: filepath.Join(commonDir, "worktrees", "feature", "index"), Op: fsnotify.Write}, false, false},
+		{"worktree branch switch", fsnotify.Event{Name: filepath.Join(commonDir, "worktrees", "feature", "HEAD"), Op: fsnotify.Create}, true, false},
+		{"main branch switch", fsnotify.Event{Name: filepath.Join(commonDir, "HEAD"), Op: fsnotify.Create}, true, false},
+		{"index update", fsnotify.Event{Name: filepath.Join(commonDir, "index"), Op: fsnotify.Write}, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			relevant, removed := w.handleEvent(tt.event)
+			if relevant != tt.wantRelevant || removed != tt.wantRemoved {
+				t.Errorf("handleEvent() = %v, %v, want %v, %v", relevant, removed, tt.wantRelevant, tt.wantRemoved)
+			}
+		})
+	}
+
+	watched := false
+	for _, path := range fsw.WatchList() {
+		if path == filepath.Join(linksDir, "newdir") {
+			watched = true
+		}
+	}
+	if !watched {
+		t.Error("handleEvent() did not start watching the new directory")
+	}
+}
+
+func TestPruneOrphans(t *testing.T) {
+	tmpDir := t.TempDir()
+	linksDir := filepath.Join(tmpDir, "links")
+	worktree := filepath.Join(tmpDir, "worktree")
+	kept := filepath.Join(linksDir, "kept.txt")
+	copied := filepath.Join(linksDir, "copied.txt")
+
+	for _, dir := range []string{linksDir, filepath.Join(worktree, "sub")} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("failed to create dir: %v", err)
+		}
+	}
+	for _, file := range []string{kept, copied, filepath.Join(worktree, "copied.txt")} {
+		if err := os.WriteFile(file, []byte("test"), 0644); err != nil {
+			t.Fatalf("failed to create file: %v", err)
+		}
+	}
+	if err := os.Symlink(kept, filepath.Join(worktree, "kept.txt")); err != nil {
+		t.Fatalf("failed to create symlink: %v", err)
+	}
+	if err := os.Symlink(filepath.Join(linksDir, "sub", "gone.txt"), filepath.Join(worktree, "sub", "gone.txt")); err != nil {
+		t.Fatalf("failed to create symlink: %v", err)
+	}
+
+	hash, err := hashFile(copied)
+	if err != nil {
+		t.Fatalf("hashFile() error = %v", err)
+	}
+	l := &linker{linksDirs: []string{linksDir}, records: &linkRecords{Entries: map[string]linkRecord{
+		filepath.Join(worktree, "copied.txt"): {Source: copied, Mode: modeCopy, Hash: hash},
+	}, Dirs: []string{filepath.Join(worktree, "sub")}}}
+	if err := os.Remove(copied); err != nil {
+		t.Fatalf("failed to remove source: %v", err)
+	}
+
+	removed, err := pruneOrphans(context.Background(), l, worktree)
+	if err != nil {
+		t.Fatalf("pruneOrphans() error = %v", err)
+	}
+	if want := []string{filepath.Join("sub", "gone.txt"), "copied.txt"}; !reflect.DeepEqual(removed, want) {
+		t.Errorf("pruneOrphans() = %v, want %v", removed, want)
+	}
+
+	if _, err := os.Lstat(filepath.Join(worktree, "kept.txt")); err != nil {
+		t.Errorf("pruneOrphans() removed a link whose source exists: %v", err)
+	}
+	for _, path := range []string{filepath.Join(worktree, "sub"), filepath.Join(worktree, "copied.txt")} {
+		if _, err := os.Lstat(path); !os.IsNotExist(err) {
+			t.Errorf("pruneOrphans() left %s behind", path)
+		}
+	}
+	if len(l.records.Entries) != 0 {
+		t.Errorf("pruneOrphans() kept records %v", l.records.Entries)
+	}
+}
@@ -0,0 +1,124 @@
+package linkworktrees
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/oscarteg/toolbox/internal/git"
+)
+
+// worktreeFilter selects the worktrees a command operates on
+type worktreeFilter struct {
+	includeMain   bool
+	includeLocked bool
+	paths         []string // absolute worktree paths to select, all when empty
+	branches      []string // branch glob patterns to select, all when empty
+	excludes      []string // glob patterns matched against the worktree path and its base name
+}
+
+// skipReason explains why a worktree can never be linked into, or returns an empty string
+func (f worktreeFilter) skipReason(wt git.Worktree) string {
+	switch {
+	case wt.Bare:
+		return "bare"
+	case wt.Prunable:
+		return "prunable"
+	case wt.Locked && !f.includeLocked:
+		return "locked"
+	default:
+		return ""
+	}
+}
+
+// matches reports whether the worktree is selected by the filter, ignoring its skip reasons
+func (f worktreeFilter) matches(wt git.Worktree) (bool, error) {
+	for _, pattern := range f.excludes {
+		for _, name := range []string{wt.Path, filepath.Base(wt.Path)} {
+			ok, err := filepath.Match(pattern, name)
+			if err != nil {
+				return false, fmt.Errorf("invalid exclude pattern %q: %w", pattern, err)
+			}
+			if ok {
+				return false, nil
+			}
+		}
+	}
+
+	// Naming the main worktree with --worktree selects it without --include-main
+	if len(f.paths) > 0 {
+		for _, p := range f.paths {
+			if samePath(p, wt.Path) {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+
+	if wt.Main && !f.includeMain {
+		return false, nil
+	}
+
+	if len(f.branches) == 0 {
+		return true, nil
+	}
+	if wt.BranchName() == "" {
+		return false, nil
+	}
+	for _, pattern := range f.branches {
+		ok, err := path.Match(pattern, wt.BranchName())
+		if err != nil {
+			return false, fmt.Errorf("invalid branch pattern %q: %w", pattern, err)
+		}
+		if ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// apply returns the paths of the selected worktrees, reporting skipped ones when verbose
+func (f worktreeFilter) apply(worktrees []git.Worktree, verbose bool) ([]string, error) {
+	paths := make([]string, 0, len(worktrees))
+
+	for _, wt := range worktrees {
+		ok, err := f.matches(wt)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+
+		if reason := f.skipReason(wt); reason != "" {
+			if verbose {
+				fmt.Fprintf(os.Stderr, "Skipping %s worktree %s\n", reason, wt.Path)
+			}
+			continue
+		}
+		paths = append(paths, wt.Path)
+	}
+
+	return paths, nil
+}
+
+// samePath reports whether both paths name the same location, resolving symlinks where possible
+func samePath(a, b string) bool {
+	if filepath.Clean(a) == filepath.Clean(b) {
+		return true
+	}
+	realA, errA := filepath.EvalSymlinks(a)
+	realB, errB := filepath.EvalSymlinks(b)
+	return errA == nil && errB == nil && realA == realB
+}
+
+// getWorktrees returns the paths of the worktrees of the repository at dir selected by filter
+func getWorktrees(ctx context.Context, dir string, filter worktreeFilter, verbose bool) ([]string, error) {
+	worktrees, err := git.ListWorktrees(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	return filter.apply(worktrees, verbose)
+}
@@ -0,0 +1,107 @@
+package linkworktrees
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/oscarteg/toolbox/internal/git"
+)
+
+const worktreeListOutput = `worktree /repo
+HEAD 1111111111111111111111111111111111111111
+branch refs/heads/main
+
+worktree /worktrees/feature-login
+HEAD 2222222222222222222222222222222222222222
+branch refs/heads/feature/login
+
+worktree /worktrees/detached
+HEAD 3333333333333333333333333333333333333333
+detached
+
+worktree /worktrees/usb
+HEAD 4444444444444444444444444444444444444444
+branch refs/heads/release
+locked on removable drive
+
+worktree /worktrees/gone
+HEAD 5555555555555555555555555555555555555555
+branch refs/heads/old
+prunable gitdir file points to non-existent location
+
+`
+
+func TestWorktreeFilterApply(t *testing.T) {
+	worktrees, err := git.ParseWorktrees([]byte(worktreeListOutput), false)
+	if err != nil {
+		t.Fatalf("ParseWorktrees() error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		filter  worktreeFilter
+		want    []string
+		wantErr bool
+	}{
+		{
+			name:   "default skips main, locked and prunable",
+			filter: worktreeFilter{},
+			want:   []string{"/worktrees/feature-login", "/worktrees/detached"},
+		},
+		{
+			name:   "include main and locked",
+			filter: worktreeFilter{includeMain: true, includeLocked: true},
+			want:   []string{"/repo", "/worktrees/feature-login", "/worktrees/detached", "/worktrees/usb"},
+		},
+		{
+			name:   "select by path includes main",
+			filter: worktreeFilter{paths: []string{"/repo", "/worktrees/detached/"}},
+			want:   []string{"/repo", "/worktrees/detached"},
+		},
+		{
+			name:   "select prunable by path is still skipped",
+			filter: worktreeFilter{paths: []string{"/worktrees/gone"}},
+			want:   []string{},
+		},
+		{
+			name:   "select by branch glob",
+			filter: worktreeFilter{branches: []string{"feature/*"}},
+			want:   []string{"/worktrees/feature-login"},
+		},
+		{
+			name:   "branch filter never matches detached",
+			filter: worktreeFilter{branches: []string{"*", "*/*"}},
+			want:   []string{"/worktrees/feature-login"},
+		},
+		{
+			name:   "exclude by base name",
+			filter: worktreeFilter{excludes: []string{"feature-*"}},
+			want:   []string{"/worktrees/detached"},
+		},
+		{
+			name:   "exclude by full path",
+			filter: worktreeFilter{includeMain: true, excludes: []string{"/repo"}},
+			want:   []string{"/worktrees/feature-login", "/worktrees/detached"},
+		},
+		{
+			name:    "invalid pattern",
+			filter:  worktreeFilter{excludes: []string{"["}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.filter.apply(worktrees, false)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("apply() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("apply() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
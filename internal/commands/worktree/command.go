@@ -0,0 +1,125 @@
+package worktree
+
+import (
+	"github.com/urfave/cli/v3"
+)
+
+// NewCommand creates a new worktree command
+func NewCommand() *cli.Command {
+	return &cli.Command{
+		Name:    "worktree",
+		Aliases: []string{"wt"},
+		Usage:   "Create and remove git worktrees along with their links",
+		Commands: []*cli.Command{
+			{
+				Name:      "new",
+				Usage:     "Create a worktree, link the links folder into it and run bootstrap commands",
+				ArgsUsage: "<name>",
+				Description: `Creates a worktree for <name> with 'git worktree add', links the links directory
+into it like 'toolbox linkworktrees --worktree <path>' and runs the bootstrap commands
+of the repository inside it.
+
+The path and branch of the worktree are templates. {{.Name}} is the name as given,
+{{.Slug}} the name with slashes replaced by dashes and {{.Repo}} the directory name of
+the main worktree. A relative path is taken relative to the main worktree, wherever the
+command runs from. The branch is checked out when it exists, and created from --base
+(default: HEAD) otherwise.
+
+Defaults come from the [worktree] section of the toolbox.links.toml manifest:
+
+  [worktree]
+  dir = "../{{.Repo}}-{{.Slug}}"   # default: ../{{.Slug}}
+  branch = "{{.Name}}"             # default: {{.Name}}
+  base = "origin/main"             # default: HEAD
+  bootstrap = ["go mod download", "direnv allow"]
+
+EXAMPLES:
+  toolbox worktree new feature-x            # ../feature-x on a new feature-x branch
+  toolbox wt new feature/login --base main  # ../feature-login on feature/login, from main
+  toolbox wt new hotfix --no-bootstrap      # Skip the bootstrap commands
+  toolbox wt new spike --dry-run            # Preview what would be done`,
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "dir",
+						Usage: "Template for the worktree path, relative to the main worktree",
+					},
+					&cli.StringFlag{
+						Name:    "branch",
+						Aliases: []string{"b"},
+						Usage:   "Template for the branch to check out",
+					},
+					&cli.StringFlag{
+						Name:  "base",
+						Usage: "Commit to create a new branch from (default: HEAD)",
+					},
+					&cli.StringSliceFlag{
+						Name:    "links-dir",
+						Aliases: []string{"d"},
+						Usage:   "Directory containing files to link, repeatable (default: the manifest layers or links)",
+					},
+					&cli.StringFlag{
+						Name:    "manifest",
+						Aliases: []string{"m"},
+						Usage:   "Manifest with the [worktree] settings and entries to link (default: toolbox.links.toml or toolbox.links.yaml if present)",
+					},
+					&cli.BoolFlag{
+						Name:  "no-link",
+						Usage: "Do not link the links folder into the new worktree",
+					},
+					&cli.BoolFlag{
+						Name:  "no-bootstrap",
+						Usage: "Do not run the bootstrap commands",
+					},
+					&cli.BoolFlag{
+						Name:    "dry-run",
+						Aliases: []string{"n"},
+						Usage:   "Show what would be done without making changes",
+						Value:   false,
+					},
+				},
+				Action: handleNew,
+			},
+			{
+				Name:      "rm",
+				Aliases:   []string{"remove"},
+				Usage:     "Remove a worktree after removing the links made into it",
+				ArgsUsage: "<name>",
+				Description: `Removes the worktree <name>, given as its path, directory name or branch.
+
+Worktrees with uncommitted changes, or with commits that no other branch or remote
+branch contains, are refused unless --force is given. Links and copies made by
+'toolbox linkworktrees' do not count as changes: they are removed first, so
+'git worktree remove' does not trip over them. --delete-branch also deletes the
+branch of the worktree, as long as it is merged.
+
+EXAMPLES:
+  toolbox worktree rm feature-x                  # Remove ../feature-x
+  toolbox wt rm feature/login --delete-branch    # Also delete the merged branch
+  toolbox wt rm spike --force                    # Discard changes and unpushed commits`,
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:    "force",
+						Aliases: []string{"f"},
+						Usage:   "Remove the worktree despite uncommitted changes, unpushed commits or a lock",
+					},
+					&cli.BoolFlag{
+						Name:  "delete-branch",
+						Usage: "Delete the branch of the worktree too, if it is merged",
+					},
+					&cli.StringSliceFlag{
+						Name:    "links-dir",
+						Aliases: []string{"d"},
+						Usage:   "Directory containing files to link, repeatable (default: the manifest layers or links)",
+					},
+					&cli.BoolFlag{
+						Name:    "dry-run",
+						Aliases: []string{"n"},
+						Usage:   "Show what would be done without making changes",
+						Value:   false,
+					},
+				},
+				Action: handleRemove,
+			},
+		},
+	}
+}
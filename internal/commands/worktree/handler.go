@@ -0,0 +1,243 @@
+package worktree
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/template"
+
+	"github.com/oscarteg/toolbox/internal/commands/linkworktrees"
+	"github.com/oscarteg/toolbox/internal/git"
+	"github.com/urfave/cli/v3"
+)
+
+const (
+	defaultDirTemplate    = "../{{.Slug}}"
+	defaultBranchTemplate = "{{.Name}}"
+)
+
+// newOptions holds configuration for creating a worktree
+type newOptions struct {
+	name      string
+	dir       string // rendered path of the worktree
+	branch    string // rendered branch name
+	base      string
+	baseSet   bool
+	linksDirs []string // empty to use the layers of the manifest or links
+	manifest  string
+	bootstrap []string
+	noLink    bool
+	dryRun    bool
+	verbose   bool
+}
+
+// templateData is what the dir and branch templates can refer to
+type templateData struct {
+	Name string // name as given on the command line
+	Slug string // name with path separators replaced, safe to use as a directory name
+	Repo string // directory name of the main worktree
+}
+
+func handleNew(ctx context.Context, cmd *cli.Command) error {
+	opts, err := newNewOptions(ctx, cmd)
+	if err != nil {
+		return err
+	}
+
+	if opts.dryRun {
+		fmt.Println("DRY RUN: No changes will be made")
+		fmt.Println()
+	}
+
+	exists, err := git.BranchExists(ctx, "", opts.branch)
+	if err != nil {
+		return err
+	}
+	base := ""
+	switch {
+	case exists && opts.baseSet:
+		return fmt.Errorf("branch %s already exists, cannot create it from %s", opts.branch, opts.base)
+	case !exists:
+		base = opts.base
+		if base == "" {
+			base = "HEAD"
+		}
+	}
+
+	if opts.dryRun {
+		if exists {
+			fmt.Printf("Would create worktree %s on existing branch %s\n", opts.dir, opts.branch)
+		} else {
+			fmt.Printf("Would create worktree %s on new branch %s from %s\n", opts.dir, opts.branch, base)
+		}
+	} else {
+		if err := git.AddWorktree(ctx, "", opts.dir, opts.branch, base); err != nil {
+			return err
+		}
+		fmt.Printf("Created worktree %s on branch %s\n", opts.dir, opts.branch)
+	}
+	fmt.Println()
+
+	if err := linkNew(ctx, opts); err != nil {
+		return fmt.Errorf("worktree %s was created, but linking failed: %w", opts.dir, err)
+	}
+
+	for _, command := range opts.bootstrap {
+		if opts.dryRun {
+			fmt.Printf("Would run: %s\n", command)
+			continue
+		}
+
+		fmt.Printf("Running: %s\n", command)
+		c := exec.CommandContext(ctx, "sh", "-c", command)
+		c.Dir = opts.dir
+		c.Stdout = os.Stdout
+		c.Stderr = os.Stderr
+		if err := c.Run(); err != nil {
+			return fmt.Errorf("worktree %s was created, but bootstrap command %q failed: %w", opts.dir, command, err)
+		}
+	}
+
+	if !opts.dryRun {
+		fmt.Printf("Worktree %s is ready\n", opts.dir)
+	}
+	return nil
+}
+
+// newNewOptions reads the flags and the [worktree] manifest section, and renders the templates
+func newNewOptions(ctx context.Context, cmd *cli.Command) (newOptions, error) {
+	opts := newOptions{
+		name:      cmd.Args().First(),
+		base:      cmd.String("base"),
+		baseSet:   cmd.IsSet("base"),
+		linksDirs: cmd.StringSlice("links-dir"),
+		manifest:  cmd.String("manifest"),
+		noLink:    cmd.Bool("no-link"),
+		dryRun:    cmd.Bool("dry-run"),
+		verbose:   cmd.Root().Bool("verbose"),
+	}
+
+	if opts.name == "" {
+		return opts, fmt.Errorf("missing worktree name, usage: toolbox worktree new <name>")
+	}
+
+	worktrees, err := git.ListWorktrees(ctx, "")
+	if err != nil {
+		return opts, err
+	}
+	mainPath := worktrees[0].Path
+
+	// Relative links directories are found in the main worktree, like linkworktrees does
+	if len(opts.linksDirs) > 0 && !opts.noLink && !slices.ContainsFunc(opts.linksDirs, func(dir string) bool {
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(mainPath, dir)
+		}
+		return isDir(dir)
+	}) {
+		return opts, fmt.Errorf("error: %w: '%s'", linkworktrees.ErrNoLinksDir, strings.Join(opts.linksDirs, "', '"))
+	}
+
+	cfg, err := linkworktrees.LoadWorktreeConfig(ctx, opts.manifest)
+	if err != nil {
+		return opts, err
+	}
+
+	dirTemplate := firstNonEmpty(cmd.String("dir"), cfg.Dir, defaultDirTemplate)
+	branchTemplate := firstNonEmpty(cmd.String("branch"), cfg.Branch, defaultBranchTemplate)
+	if !opts.baseSet {
+		opts.base = cfg.Base
+	}
+	if !cmd.Bool("no-bootstrap") {
+		opts.bootstrap = cfg.Bootstrap
+	}
+
+	data := newTemplateData(opts.name, mainPath)
+
+	dir, err := render("dir", dirTemplate, data)
+	if err != nil {
+		return opts, err
+	}
+	opts.dir = resolveDir(dir, mainPath)
+	if opts.branch, err = render("branch", branchTemplate, data); err != nil {
+		return opts, err
+	}
+
+	return opts, nil
+}
+
+// resolveDir returns the rendered worktree path dir, taking relative paths relative to the main worktree so the
+// default ../{{.Slug}} ends up next to it wherever the command runs from
+func resolveDir(dir, mainPath string) string {
+	if filepath.IsAbs(dir) {
+		return filepath.Clean(dir)
+	}
+	return filepath.Join(mainPath, dir)
+}
+
+// linkNew links the links directories into the new worktree, unless there is nothing to link
+func linkNew(ctx context.Context, opts newOptions) error {
+	if opts.noLink {
+		return nil
+	}
+
+	err := linkworktrees.Link(ctx, linkworktrees.Options{
+		LinksDirs: opts.linksDirs,
+		Manifest:  opts.manifest,
+		DryRun:    opts.dryRun,
+		Verbose:   opts.verbose,
+	}, opts.dir)
+	if errors.Is(err, linkworktrees.ErrNoLinksDir) && len(opts.linksDirs) == 0 {
+		if opts.verbose {
+			fmt.Printf("No links directory, nothing to link\n\n")
+		}
+		return nil
+	}
+	return err
+}
+
+// isDir reports whether path is a directory, following symlinks
+func isDir(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+// newTemplateData returns the template data for name in the repository whose main worktree is at mainPath
+func newTemplateData(name, mainPath string) templateData {
+	return templateData{
+		Name: name,
+		Slug: strings.ReplaceAll(name, "/", "-"),
+		Repo: strings.TrimSuffix(filepath.Base(mainPath), ".git"),
+	}
+}
+
+// render executes the template text with data, failing on unknown fields and empty results
+func render(name, text string, data templateData) (string, error) {
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("invalid %s template %q: %w", name, text, err)
+	}
+
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", fmt.Errorf("failed to render %s template %q: %w", name, text, err)
+	}
+	if strings.TrimSpace(b.String()) == "" {
+		return "", fmt.Errorf("%s template %q renders to an empty string", name, text)
+	}
+	return b.String(), nil
+}
+
+// firstNonEmpty returns the first of values that is not empty
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,75 @@
+package worktree
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestNewTemplateData(t *testing.T) {
+	tests := []struct {
+		name     string
+		mainPath string
+		want     templateData
+	}{
+		{"feature-x", "/src/toolbox", templateData{Name: "feature-x", Slug: "feature-x", Repo: "toolbox"}},
+		{"feature/login", "/src/toolbox", templateData{Name: "feature/login", Slug: "feature-login", Repo: "toolbox"}},
+		{"hotfix", "/src/toolbox.git", templateData{Name: "hotfix", Slug: "hotfix", Repo: "toolbox"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := newTemplateData(tt.name, tt.mainPath); got != tt.want {
+				t.Errorf("newTemplateData() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRender(t *testing.T) {
+	data := templateData{Name: "feature/login", Slug: "feature-login", Repo: "toolbox"}
+
+	tests := []struct {
+		name    string
+		text    string
+		want    string
+		wantErr bool
+	}{
+		{name: "default dir", text: defaultDirTemplate, want: "../feature-login"},
+		{name: "default branch", text: defaultBranchTemplate, want: "feature/login"},
+		{name: "repo prefix", text: "../{{.Repo}}-{{.Slug}}", want: "../toolbox-feature-login"},
+		{name: "unknown field", text: "{{.Branch}}", wantErr: true},
+		{name: "invalid syntax", text: "{{.Name", wantErr: true},
+		{name: "empty result", text: "{{if false}}x{{end}}", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := render("dir", tt.text, data)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("render() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("render() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveDir(t *testing.T) {
+	tests := []struct {
+		dir  string
+		want string
+	}{
+		{dir: "../feature-login", want: "/src/feature-login"},
+		{dir: "worktrees/feature-login", want: "/src/toolbox/worktrees/feature-login"},
+		{dir: "/tmp/feature-login/", want: "/tmp/feature-login"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.dir, func(t *testing.T) {
+			if got := resolveDir(filepath.FromSlash(tt.dir), filepath.FromSlash("/src/toolbox")); got != filepath.FromSlash(tt.want) {
+				t.Errorf("resolveDir() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
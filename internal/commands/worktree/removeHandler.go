@@ -0,0 +1,191 @@
+package worktree
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/oscarteg/toolbox/internal/commands/linkworktrees"
+	"github.com/oscarteg/toolbox/internal/git"
+	"github.com/urfave/cli/v3"
+)
+
+// removeOptions holds configuration for removing a worktree
+type removeOptions struct {
+	name         string
+	force        bool
+	deleteBranch bool
+	links        linkworktrees.Options
+}
+
+func handleRemove(ctx context.Context, cmd *cli.Command) error {
+	opts := removeOptions{
+		name:         cmd.Args().First(),
+		force:        cmd.Bool("force"),
+		deleteBranch: cmd.Bool("delete-branch"),
+		links: linkworktrees.Options{
+			LinksDirs: cmd.StringSlice("links-dir"),
+			DryRun:    cmd.Bool("dry-run"),
+			Verbose:   cmd.Root().Bool("verbose"),
+		},
+	}
+	if opts.name == "" {
+		return fmt.Errorf("missing worktree name, usage: toolbox worktree rm <name>")
+	}
+
+	if opts.links.DryRun {
+		fmt.Println("DRY RUN: No changes will be made")
+		fmt.Println()
+	}
+
+	worktrees, err := git.ListWorktrees(ctx, "")
+	if err != nil {
+		return err
+	}
+	wt, err := findWorktree(worktrees, opts.name)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case wt.Prunable:
+		return fmt.Errorf("worktree %s no longer exists, use 'git worktree prune' to clean it up", wt.Path)
+	case wt.Locked && !opts.force:
+		return fmt.Errorf("worktree %s is locked%s, use --force to remove it anyway", wt.Path, lockReason(wt))
+	}
+
+	if err := checkSafeToRemove(ctx, opts, wt); err != nil {
+		return err
+	}
+
+	// Links are untracked files as far as git is concerned, and would block git worktree remove
+	if err := linkworktrees.Unlink(ctx, opts.links, wt.Path); err != nil {
+		return err
+	}
+
+	if opts.links.DryRun {
+		fmt.Printf("Would remove worktree %s\n", wt.Path)
+		if opts.deleteBranch && wt.BranchName() != "" {
+			fmt.Printf("Would delete branch %s if merged\n", wt.BranchName())
+		}
+		return nil
+	}
+
+	// Git runs from the main worktree, the current directory may be the worktree that is removed
+	mainPath := worktrees[0].Path
+	if err := git.RemoveWorktree(ctx, mainPath, wt.Path, opts.force); err != nil {
+		return err
+	}
+	fmt.Printf("Removed worktree %s\n", wt.Path)
+
+	if opts.deleteBranch && wt.BranchName() != "" {
+		err := git.DeleteBranch(ctx, mainPath, wt.BranchName())
+		switch {
+		case errors.Is(err, git.ErrNotMerged):
+			// The worktree is gone either way, an unmerged branch is simply kept
+			fmt.Printf("Kept branch %s, it is not fully merged (delete it with git branch -D)\n", wt.BranchName())
+		case err != nil:
+			return fmt.Errorf("removed worktree %s but failed to delete branch %s: %w", wt.Path, wt.BranchName(), err)
+		default:
+			fmt.Printf("Deleted branch %s\n", wt.BranchName())
+		}
+	}
+	return nil
+}
+
+// checkSafeToRemove refuses worktrees with uncommitted changes or commits no other branch contains, unless forced.
+// Links and copies made by linkworktrees do not count as changes.
+func checkSafeToRemove(ctx context.Context, opts removeOptions, wt git.Worktree) error {
+	managed, err := linkworktrees.ManagedPaths(ctx, opts.links, wt.Path)
+	if err != nil {
+		return err
+	}
+	isManaged := make(map[string]bool, len(managed))
+	for _, p := range managed {
+		isManaged[p] = true
+	}
+
+	paths, err := git.ChangedPaths(ctx, wt.Path)
+	if err != nil {
+		return err
+	}
+	var changes []string
+	for _, p := range paths {
+		if !isManaged[filepath.Join(wt.Path, p)] {
+			changes = append(changes, p)
+		}
+	}
+
+	unpushed, err := git.UnpushedCommits(ctx, wt.Path, wt.BranchName())
+	if err != nil {
+		return err
+	}
+
+	if len(changes) == 0 && unpushed == 0 {
+		return nil
+	}
+	if opts.force {
+		fmt.Printf("Removing %s despite %d uncommitted changes and %d unpushed commits\n\n", wt.Path, len(changes), unpushed)
+		return nil
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "refusing to remove %s, use --force to remove it anyway:", wt.Path)
+	if len(changes) > 0 {
+		sort.Strings(changes)
+		fmt.Fprintf(&b, "\n  %d uncommitted changes:\n    %s", len(changes), strings.Join(changes, "\n    "))
+	}
+	if unpushed > 0 {
+		fmt.Fprintf(&b, "\n  %d unpushed commits, not on any other branch or remote", unpushed)
+	}
+	return fmt.Errorf("%s", b.String())
+}
+
+// findWorktree returns the worktree name refers to, by path, directory name or branch
+func findWorktree(worktrees []git.Worktree, name string) (git.Worktree, error) {
+	abs, err := filepath.Abs(name)
+	if err != nil {
+		return git.Worktree{}, err
+	}
+	slug := strings.ReplaceAll(name, "/", "-")
+
+	var matches []git.Worktree
+	for _, wt := range worktrees {
+		if wt.Path == abs {
+			matches = []git.Worktree{wt}
+			break
+		}
+		base := filepath.Base(wt.Path)
+		if base == name || base == slug || wt.BranchName() == name {
+			matches = append(matches, wt)
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return git.Worktree{}, fmt.Errorf("no worktree found for %q", name)
+	case 1:
+	default:
+		paths := make([]string, len(matches))
+		for i, wt := range matches {
+			paths[i] = wt.Path
+		}
+		return git.Worktree{}, fmt.Errorf("%q matches several worktrees, pass a path instead:\n  %s", name, strings.Join(paths, "\n  "))
+	}
+
+	if wt := matches[0]; wt.Main || wt.Bare {
+		return git.Worktree{}, fmt.Errorf("refusing to remove the main worktree %s", wt.Path)
+	}
+	return matches[0], nil
+}
+
+// lockReason formats the reason a worktree is locked for error messages
+func lockReason(wt git.Worktree) string {
+	if wt.LockedReason == "" {
+		return ""
+	}
+	return " (" + wt.LockedReason + ")"
+}
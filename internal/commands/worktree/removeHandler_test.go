@@ -0,0 +1,42 @@
+package worktree
+
+import (
+	"testing"
+
+	"github.com/oscarteg/toolbox/internal/git"
+)
+
+func TestFindWorktree(t *testing.T) {
+	worktrees := []git.Worktree{
+		{Path: "/src/toolbox", Branch: "refs/heads/main", Main: true},
+		{Path: "/src/feature-login", Branch: "refs/heads/feature/login"},
+		{Path: "/src/hotfix", Branch: "refs/heads/hotfix"},
+		{Path: "/tmp/hotfix", Detached: true},
+	}
+
+	tests := []struct {
+		name     string
+		arg      string
+		wantPath string
+		wantErr  bool
+	}{
+		{name: "by path", arg: "/tmp/hotfix", wantPath: "/tmp/hotfix"},
+		{name: "by branch", arg: "feature/login", wantPath: "/src/feature-login"},
+		{name: "by directory name", arg: "feature-login", wantPath: "/src/feature-login"},
+		{name: "ambiguous", arg: "hotfix", wantErr: true},
+		{name: "main worktree", arg: "main", wantErr: true},
+		{name: "unknown", arg: "nope", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := findWorktree(worktrees, tt.arg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("findWorktree() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got.Path != tt.wantPath {
+				t.Errorf("findWorktree() = %s, want %s", got.Path, tt.wantPath)
+			}
+		})
+	}
+}
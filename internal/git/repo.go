@@ -0,0 +1,169 @@
+package git
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// GitPath returns the absolute path git uses for name inside the git directory of dir, taking
+// worktrees and settings like core.hooksPath into account. An empty dir means the current directory.
+func GitPath(ctx context.Context, dir, name string) (string, error) {
+	return revParse(ctx, dir, "--path-format=absolute", "--git-path", name)
+}
+
+// CommonDir returns the absolute path of the git directory shared by all worktrees of the repository at dir
+func CommonDir(ctx context.Context, dir string) (string, error) {
+	return revParse(ctx, dir, "--path-format=absolute", "--git-common-dir")
+}
+
+// HooksDir returns the absolute path of the directory git runs hooks from in dir, honoring core.hooksPath
+func HooksDir(ctx context.Context, dir string) (string, error) {
+	return GitPath(ctx, dir, "hooks")
+}
+
+// BranchExists reports whether the local branch exists in the repository at dir
+func BranchExists(ctx context.Context, dir, branch string) (bool, error) {
+	_, err := run(ctx, dir, "show-ref", "--verify", "--quiet", "refs/heads/"+branch)
+	if err == nil {
+		return true, nil
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+		return false, nil
+	}
+	return false, err
+}
+
+// ErrNotMerged is returned by DeleteBranch when git refuses to delete a branch that is not fully merged
+var ErrNotMerged = errors.New("branch is not fully merged")
+
+// DeleteBranch deletes a local branch, which git only allows once it is merged
+func DeleteBranch(ctx context.Context, dir, branch string) error {
+	_, err := run(ctx, dir, "branch", "--delete", branch)
+	// run forces the C locale, so the message is always the English one
+	if err != nil && strings.Contains(strings.ToLower(err.Error()), "not fully merged") {
+		return fmt.Errorf("%w: %s", ErrNotMerged, branch)
+	}
+	return err
+}
+
+// ChangedPaths returns the paths with uncommitted changes in the worktree at dir, relative to
+// its root. Untracked files are listed one by one, ignored files are left out.
+func ChangedPaths(ctx context.Context, dir string) ([]string, error) {
+	output, err := run(ctx, dir, "status", "--porcelain", "-z", "--untracked-files=all")
+	if err != nil {
+		return nil, err
+	}
+	return parseStatus(output), nil
+}
+
+// UnpushedCommits counts the commits in HEAD of the worktree at dir that no other branch or remote
+// branch contains, which are lost when branch is deleted. branch is empty for a detached HEAD.
+func UnpushedCommits(ctx context.Context, dir, branch string) (int, error) {
+	args := []string{"rev-list", "--count", "HEAD", "--not"}
+	if branch != "" {
+		// Patterns applied to --branches are relative to refs/heads
+		args = append(args, "--exclude="+branch)
+	}
+	args = append(args, "--branches", "--remotes")
+
+	output, err := run(ctx, dir, args...)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(output)))
+}
+
+// Submodule is a submodule of a worktree, as listed by git submodule status
+type Submodule struct {
+	Path       string // relative to the worktree root
+	CheckedOut bool   // false when the submodule is not initialized and its directory is empty
+}
+
+// Submodules returns the submodules of the worktree at dir, including the ones nested in checked out submodules
+func Submodules(ctx context.Context, dir string) ([]Submodule, error) {
+	output, err := run(ctx, dir, "submodule", "status", "--recursive")
+	if err != nil {
+		return nil, err
+	}
+	return parseSubmoduleStatus(output), nil
+}
+
+// parseSubmoduleStatus returns the submodules of git submodule status output. Each line holds a state
+// character, the commit, the path and, for submodules that are checked out, the described commit in parentheses.
+func parseSubmoduleStatus(output []byte) []Submodule {
+	var submodules []Submodule
+
+	for _, line := range strings.Split(string(output), "\n") {
+		if len(line) < 2 {
+			continue
+		}
+		_, path, ok := strings.Cut(line[1:], " ")
+		if !ok {
+			continue
+		}
+		if i := strings.LastIndex(path, " ("); i >= 0 && strings.HasSuffix(path, ")") {
+			path = path[:i]
+		}
+		submodules = append(submodules, Submodule{Path: path, CheckedOut: line[0] != '-'})
+	}
+	return submodules
+}
+
+// parseStatus returns the paths of git status --porcelain -z output
+func parseStatus(output []byte) []string {
+	var paths []string
+
+	fields := strings.Split(string(output), "\x00")
+	for i := 0; i < len(fields); i++ {
+		entry := fields[i]
+		if len(entry) < 4 {
+			continue
+		}
+		paths = append(paths, entry[3:])
+		// Renames and copies are followed by the original path
+		if entry[0] == 'R' || entry[0] == 'C' {
+			i++
+		}
+	}
+	return paths
+}
+
+// revParse runs git rev-parse in dir and returns its trimmed output
+func revParse(ctx context.Context, dir string, args ...string) (string, error) {
+	output, err := run(ctx, dir, append([]string{"rev-parse"}, args...)...)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// run executes git in dir, or the current directory when dir is empty, and returns its output.
+// The error carries the message git printed, if any. Git runs with the C locale, so its
+// messages can be matched whatever language the user has configured.
+func run(ctx context.Context, dir string, args ...string) ([]byte, error) {
+	name := args[0]
+	if dir != "" {
+		args = append([]string{"-C", dir}, args...)
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Env = append(os.Environ(), "LC_ALL=C")
+	cmd.Stderr = &stderr
+
+	output, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("failed to execute git %s: %w: %s", name, err, msg)
+		}
+		return nil, fmt.Errorf("failed to execute git %s: %w", name, err)
+	}
+	return output, nil
+}
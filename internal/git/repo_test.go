@@ -0,0 +1,53 @@
+package git
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseStatus(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   []string
+	}{
+		{
+			name:   "clean",
+			output: "",
+			want:   nil,
+		},
+		{
+			name:   "modified and untracked",
+			output: " M main.go\x00?? notes/todo.txt\x00A  new.go\x00",
+			want:   []string{"main.go", "notes/todo.txt", "new.go"},
+		},
+		{
+			name:   "rename skips the original path",
+			output: "R  after.go\x00before.go\x00?? a b.txt\x00",
+			want:   []string{"after.go", "a b.txt"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseStatus([]byte(tt.output)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseStatus() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseSubmoduleStatus(t *testing.T) {
+	output := " 1f0c2a7e9d3b4c5a6b7c8d9e0f1a2b3c4d5e6f70 libs/core (heads/main)\n" +
+		"+2a1b3c4d5e6f708192a3b4c5d6e7f8091a2b3c4d libs/core/vendor/dep (v1.2.0-3-g2a1b3c4)\n" +
+		"-3b2c4d5e6f708192a3b4c5d6e7f8091a2b3c4d5e docs site\n"
+
+	want := []Submodule{
+		{Path: "libs/core", CheckedOut: true},
+		{Path: "libs/core/vendor/dep", CheckedOut: true},
+		{Path: "docs site", CheckedOut: false},
+	}
+	if got := parseSubmoduleStatus([]byte(output)); !reflect.DeepEqual(got, want) {
+		t.Errorf("parseSubmoduleStatus() = %+v, want %+v", got, want)
+	}
+}
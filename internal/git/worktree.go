@@ -0,0 +1,134 @@
+// Package git wraps the git commands shared by toolbox commands
+package git
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Worktree is a single record of git worktree list --porcelain
+type Worktree struct {
+	Path           string
+	Head           string // commit checked out, empty for a bare repository
+	Branch         string // full ref name such as refs/heads/main, empty when detached or bare
+	Main           bool   // the first record, the main worktree
+	Bare           bool
+	Detached       bool
+	Locked         bool
+	LockedReason   string
+	Prunable       bool
+	PrunableReason string
+}
+
+// BranchName returns the branch without its refs/heads/ prefix
+func (w Worktree) BranchName() string {
+	return strings.TrimPrefix(w.Branch, "refs/heads/")
+}
+
+// ListWorktrees runs git worktree list in dir, or the current directory when dir is empty
+func ListWorktrees(ctx context.Context, dir string) ([]Worktree, error) {
+	output, err := run(ctx, dir, "worktree", "list", "--porcelain", "-z")
+	if err != nil {
+		return nil, err
+	}
+	return ParseWorktrees(output, true)
+}
+
+// MainWorktree returns the path of the main worktree of the repository at dir, or of the repository itself when it is bare
+func MainWorktree(ctx context.Context, dir string) (string, error) {
+	worktrees, err := ListWorktrees(ctx, dir)
+	if err != nil {
+		return "", err
+	}
+	if len(worktrees) == 0 {
+		return "", errors.New("git worktree list returned no worktrees")
+	}
+	return worktrees[0].Path, nil
+}
+
+// AddWorktree checks out branch in a new worktree at path. When base is set, branch is
+// created from it, otherwise branch must already exist.
+func AddWorktree(ctx context.Context, dir, path, branch, base string) error {
+	args := []string{"worktree", "add", path, branch}
+	if base != "" {
+		args = []string{"worktree", "add", "-b", branch, path, base}
+	}
+	_, err := run(ctx, dir, args...)
+	return err
+}
+
+// RemoveWorktree removes the worktree at path. force removes it despite local changes, or a lock.
+func RemoveWorktree(ctx context.Context, dir, path string, force bool) error {
+	args := []string{"worktree", "remove", path}
+	if force {
+		// A locked worktree needs --force twice
+		args = []string{"worktree", "remove", "--force", "--force", path}
+	}
+	_, err := run(ctx, dir, args...)
+	return err
+}
+
+// ParseWorktrees parses porcelain output, with NUL separated fields when nul is set (git worktree list -z)
+func ParseWorktrees(output []byte, nul bool) ([]Worktree, error) {
+	sep := []byte("\n")
+	if nul {
+		sep = []byte{0}
+	}
+
+	var worktrees []Worktree
+	var current *Worktree
+
+	for _, field := range bytes.Split(output, sep) {
+		line := string(field)
+		if line == "" {
+			// An empty field ends the record
+			current = nil
+			continue
+		}
+
+		key, value, _ := strings.Cut(line, " ")
+		if key == "worktree" {
+			worktrees = append(worktrees, Worktree{Path: value, Main: len(worktrees) == 0})
+			current = &worktrees[len(worktrees)-1]
+			continue
+		}
+		if current == nil {
+			return nil, fmt.Errorf("unexpected %q outside of a worktree record", line)
+		}
+
+		switch key {
+		case "HEAD":
+			current.Head = value
+		case "branch":
+			current.Branch = value
+		case "bare":
+			current.Bare = true
+		case "detached":
+			current.Detached = true
+		case "locked":
+			current.Locked = true
+			current.LockedReason = unquoteReason(value, nul)
+		case "prunable":
+			current.Prunable = true
+			current.PrunableReason = unquoteReason(value, nul)
+		}
+		// Attributes added by newer git versions are ignored
+	}
+
+	return worktrees, nil
+}
+
+// unquoteReason undoes the C-style quoting git applies to reasons with special characters in newline separated output
+func unquoteReason(s string, nul bool) string {
+	if nul || !strings.HasPrefix(s, `"`) {
+		return s
+	}
+	if unquoted, err := strconv.Unquote(s); err == nil {
+		return unquoted
+	}
+	return s
+}
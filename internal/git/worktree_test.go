@@ -0,0 +1,106 @@
+package git
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const head = "40e4edcb1ccf727a71aac70e6b68829544d01206"
+
+func TestParseWorktrees(t *testing.T) {
+	tests := []struct {
+		name    string
+		fixture string
+		nul     bool
+		want    []Worktree
+	}{
+		{
+			name:    "newline separated",
+			fixture: "porcelain.txt",
+			want: []Worktree{
+				{Path: "/tmp/fx/repo", Head: head, Branch: "refs/heads/master", Main: true},
+				{Path: "/tmp/fx/detached", Head: head, Detached: true},
+				{Path: "/tmp/fx/feature", Head: head, Branch: "refs/heads/feature/login"},
+				{Path: "/tmp/fx/gone", Head: head, Branch: "refs/heads/old", Prunable: true, PrunableReason: "gitdir file points to non-existent location"},
+				{Path: "/tmp/fx/plainlock", Head: head, Branch: "refs/heads/pl", Locked: true},
+				{Path: "/tmp/fx/usb", Head: head, Branch: "refs/heads/release", Locked: true, LockedReason: "on removable drive"},
+			},
+		},
+		{
+			name:    "NUL separated with a newline in a path",
+			fixture: "porcelain-z.txt",
+			nul:     true,
+			want: []Worktree{
+				{Path: "/tmp/fx/repo", Head: head, Branch: "refs/heads/master", Main: true},
+				{Path: "/tmp/fx/detached", Head: head, Detached: true},
+				{Path: "/tmp/fx/feature", Head: head, Branch: "refs/heads/feature/login"},
+				{Path: "/tmp/fx/gone", Head: head, Branch: "refs/heads/old", Prunable: true, PrunableReason: "gitdir file points to non-existent location"},
+				{Path: "/tmp/fx/new\nline", Head: head, Branch: "refs/heads/nl"},
+				{Path: "/tmp/fx/plainlock", Head: head, Branch: "refs/heads/pl", Locked: true},
+				{Path: "/tmp/fx/usb", Head: head, Branch: "refs/heads/release", Locked: true, LockedReason: "on removable drive"},
+			},
+		},
+		{
+			name:    "bare repository",
+			fixture: "porcelain-bare.txt",
+			want: []Worktree{
+				{Path: "/tmp/bare.git", Main: true, Bare: true},
+				{Path: "/tmp/bare-main", Head: head, Branch: "refs/heads/master"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output, err := os.ReadFile(filepath.Join("testdata", tt.fixture))
+			if err != nil {
+				t.Fatalf("failed to read fixture: %v", err)
+			}
+
+			got, err := ParseWorktrees(output, tt.nul)
+			if err != nil {
+				t.Fatalf("ParseWorktrees() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseWorktrees() =\n%+v\nwant\n%+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseWorktreesQuotedReason(t *testing.T) {
+	output := []byte("worktree /repo\nHEAD abc\nbranch refs/heads/main\nlocked \"line one\\nline two\"\n\n")
+
+	got, err := ParseWorktrees(output, false)
+	if err != nil {
+		t.Fatalf("ParseWorktrees() error = %v", err)
+	}
+	if len(got) != 1 || got[0].LockedReason != "line one\nline two" {
+		t.Errorf("ParseWorktrees() = %+v, want locked reason %q", got, "line one\nline two")
+	}
+}
+
+func TestParseWorktreesMalformed(t *testing.T) {
+	if _, err := ParseWorktrees([]byte("HEAD abc\n"), false); err == nil {
+		t.Error("ParseWorktrees() error = nil, want error for a field outside a record")
+	}
+}
+
+func TestBranchName(t *testing.T) {
+	tests := []struct {
+		branch string
+		want   string
+	}{
+		{"refs/heads/main", "main"},
+		{"refs/heads/feature/login", "feature/login"},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		if got := (Worktree{Branch: tt.branch}).BranchName(); got != tt.want {
+			t.Errorf("BranchName(%q) = %q, want %q", tt.branch, got, tt.want)
+		}
+	}
+}